@@ -19,6 +19,7 @@ func (h *Handler) client(router chi.Router) {
 	router.Post("/", h.clientAdd)
 	router.Route("/{id}", func(router chi.Router) {
 		router.Use(h.clientContext)
+		router.Get("/", h.clientGet)
 		router.Put("/", h.clientUpdate)
 		router.Delete("/", h.clientDelete)
 	})
@@ -54,8 +55,14 @@ func (h *Handler) clientsFilter(w http.ResponseWriter, r *http.Request) {
 // @ID create-list
 // @Accept  json
 // @Produce  json
-// @Param input body model.Clients true "list info"
-// @Success 200 {integer} integer 1
+// @Param limit query int false "page size, 50 by default"
+// @Param after query string false "next cursor of the previous page"
+// @Param sort query string false "phone, op_code, tag or tz, - prefix for descending order"
+// @Param tag query []string false "tags"
+// @Param op_code query []int false "operator codes"
+// @Param phone_prefix query []string false "phone prefixes"
+// @Param tz query []string false "time zones"
+// @Success 200 {object} model.ClientsPage
 // @Failure 400,404 {object} ErrResponse
 // @Failure 500 {object} ErrResponse
 // @Failure default {object} ErrResponse
@@ -64,10 +71,16 @@ func (h *Handler) clientsGet(w http.ResponseWriter, r *http.Request) {
 	ctx, _ := logging.GetCtxLogger(r.Context())
 	logger := h.Logger(ctx)
 
-	clients, err := h.st.GetClients(ctx)
+	query := model.ClientQuery{}
+	if err := query.ParseQuery(r.URL.Query()); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	clients, err := h.st.ListClients(ctx, query)
 	if err != nil {
-		if errors.Is(err, pkg.ErrNoData) {
-			render.Render(w, r, ErrNoData)
+		if errors.Is(err, pkg.ErrInvalidInput) {
+			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
 		logger.Err(err).Msg("clientsGet: can't get clients from DB")
@@ -75,7 +88,7 @@ func (h *Handler) clientsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	render.Render(w, r, clients)
+	render.Render(w, r, &clients)
 
 }
 
@@ -129,6 +142,32 @@ func (h *Handler) clientContext(next http.Handler) http.Handler {
 	})
 }
 
+// clientGet returns client
+// GET /api/client/{id}
+func (h *Handler) clientGet(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	uid, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	client, err := h.st.GetClientByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, pkg.ErrNotExists) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("clientGet st.GetClientByID")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	render.Render(w, r, &client)
+}
+
 // clientUpdate updates client
 func (h *Handler) clientUpdate(w http.ResponseWriter, r *http.Request) {
 	ctx, _ := logging.GetCtxLogger(r.Context())
@@ -69,6 +69,7 @@ func NewHandler(opts ...Option) (*Handler, error) {
 	h.NotFound(notFoundHandler)
 	h.Route("/api/client", h.client)
 	h.Route("/api/sending", h.sending)
+	h.Route("/api/sender", h.sender)
 	h.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong"))
 	})
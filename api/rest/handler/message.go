@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"net/http"
+	"noty/pkg"
+	"noty/pkg/logging"
+	"strconv"
+)
+
+// messageEvents
+// returns status history and delivery attempts of a sending message
+// GET /api/sending/{id}/messages/{msgId}/events
+func (h *Handler) messageEvents(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	uid, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "msgId"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	logger.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Int64(logging.MessageIDKey, msgID)
+	})
+
+	events, err := h.st.GetMessageEvents(ctx, uid, msgID)
+	if err != nil {
+		if errors.Is(err, pkg.ErrNoData) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("messageEvents: can't get message events from DB")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	render.Render(w, r, events)
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"net/http"
+	"noty/pkg/logging"
+)
+
+func (h *Handler) sender(router chi.Router) {
+	router.Get("/", h.senderStatus)
+}
+
+// senderStatus
+// returns the sender runtime status: circuit breaker state around the external send API
+func (h *Handler) senderStatus(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+
+	status := h.snd.Status(ctx)
+
+	render.Render(w, r, &status)
+}
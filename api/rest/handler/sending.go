@@ -11,6 +11,7 @@ import (
 	"noty/model"
 	"noty/pkg"
 	"noty/pkg/logging"
+	"time"
 )
 
 // TODO: find out about: - обработки активных рассылок и отправки сообщений клиентам
@@ -18,25 +19,39 @@ import (
 func (h *Handler) sending(router chi.Router) {
 	router.Get("/", h.sendingsGenStat)
 	router.Post("/", h.sendingAdd)
+	router.Post("/preview", h.sendingAudience)
 	router.Route("/{id}", func(router chi.Router) {
 		router.Use(h.sendingContext)
-		router.Get("/", h.sendingStat)
+		router.Get("/", h.sendingGet)
+		router.Get("/messages", h.sendingStat)
 		router.Put("/", h.sendingUpdate)
 		router.Delete("/", h.sendingDelete)
+		router.Post("/pause", h.sendingState(model.SendingStatePaused))
+		router.Post("/resume", h.sendingState(model.SendingStateScheduled))
+		router.Post("/cancel", h.sendingState(model.SendingStateCancelled))
+		router.Get("/messages/{msgId}/events", h.messageEvents)
+		router.Get("/preview/{clientId}", h.sendingPreview)
 	})
 }
 
 // sendingsGenStat
 // obtaining general statistics on created sendings and the number of sent
 // messages on them, grouped by status
+// GET /api/sending?state=RUNNING&start_from=...&start_to=...&sort=-start_at&limit=20&after=...
 func (h *Handler) sendingsGenStat(w http.ResponseWriter, r *http.Request) {
 	ctx, _ := logging.GetCtxLogger(r.Context())
 	logger := h.Logger(ctx)
 
-	sendings, err := h.st.GetSendingsStatus(ctx)
+	query := model.SendingQuery{}
+	if err := query.ParseQuery(r.URL.Query()); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	sendings, err := h.st.ListSendingsStatus(ctx, query)
 	if err != nil {
-		if errors.Is(err, pkg.ErrNoData) {
-			render.Render(w, r, ErrNoData)
+		if errors.Is(err, pkg.ErrInvalidInput) {
+			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
 		logger.Err(err).Msg("sendingsGenStat: can't get sendings from DB")
@@ -47,6 +62,30 @@ func (h *Handler) sendingsGenStat(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, &sendings)
 }
 
+// sendingAudience
+// counts clients matching a filter, broken down by op code, tag and time zone,
+// and returns a page of them without creating a sending
+// POST /api/sending/preview
+func (h *Handler) sendingAudience(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	input := &model.AudienceRequest{}
+	if err := render.Bind(r, input); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	audience, err := h.st.GetAudience(ctx, input.Filter, input.Limit, input.Offset)
+	if err != nil {
+		logger.Err(err).Msg("sendingAudience: can't get audience from DB")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	render.Render(w, r, &audience)
+}
+
 // sendingContext do smth
 func (h *Handler) sendingContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -56,8 +95,36 @@ func (h *Handler) sendingContext(next http.Handler) http.Handler {
 	})
 }
 
+// sendingGet
+// returns the sending with its state and message counts by status
+// GET /api/sending/{id}
+func (h *Handler) sendingGet(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	uid, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	status, err := h.st.GetSendingStatus(ctx, uid)
+	if err != nil {
+		if errors.Is(err, pkg.ErrNotExists) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("sendingGet st.GetSendingStatus")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	render.Render(w, r, &status)
+}
+
 // sendingStat
 // obtaining detailed statistics of sent messages for a specific Sending
+// GET /api/sending/{id}/messages?status=FAILED&created_from=...&created_to=...&sort=-created_at&limit=20&after=...
 func (h *Handler) sendingStat(w http.ResponseWriter, r *http.Request) {
 	ctx, _ := logging.GetCtxLogger(r.Context())
 	logger := h.Logger(ctx)
@@ -73,22 +140,39 @@ func (h *Handler) sendingStat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := h.st.GetMessagesBySendingID(ctx, uid)
-	if err != nil {
-		logger.Err(err).Msg("sendingStat GetMessagesBySendingID")
+	query := model.MessageQuery{}
+	if err := query.ParseQuery(r.URL.Query()); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
+	if _, err := h.st.GetSendingByID(ctx, uid); err != nil {
+		if errors.Is(err, pkg.ErrNotExists) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("sendingStat st.GetSendingByID")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	messages, err := h.st.ListSendingMessages(ctx, uid, query)
+	if err != nil {
+		if errors.Is(err, pkg.ErrInvalidInput) {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		logger.Err(err).Msg("sendingStat ListSendingMessages")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
 	render.Render(w, r, &messages)
 }
 
 // sendingAdd adds new sending
 func (h *Handler) sendingAdd(w http.ResponseWriter, r *http.Request) {
-	ctx, _ := logging.GetCtxLogger(r.Context()) // I don't want to use context from r.Context()
-	// because it is canceled after the request is done
-	//ctx, _ := logging.GetCtxLogger(context.Background())
-
+	ctx, _ := logging.GetCtxLogger(r.Context())
 	logger := h.Logger(ctx)
 
 	input := &model.Sending{}
@@ -102,6 +186,10 @@ func (h *Handler) sendingAdd(w http.ResponseWriter, r *http.Request) {
 	if input.ID == uuid.Nil {
 		input.ID, _ = uuid.NewUUID()
 	}
+	input.State = model.SendingStateScheduled
+	if input.AudienceMode == "" {
+		input.AudienceMode = model.AudienceModeFrozen
+	}
 
 	logger.UpdateContext(input.GetLoggerContext)
 	ctx = logging.SetCtxLogger(ctx, *logger)
@@ -120,7 +208,7 @@ func (h *Handler) sendingAdd(w http.ResponseWriter, r *http.Request) {
 	logger.Info().Msg("new sending")
 	logger.Debug().Msgf("sending: %+v", input)
 
-	go h.snd.NewSending(ctx, *input)
+	h.snd.NewSending(ctx, *input)
 
 	render.Render(w, r, input)
 
@@ -160,9 +248,98 @@ func (h *Handler) sendingUpdate(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info().Msg("update sending")
 
+	h.snd.NewSending(ctx, sending)
+
 	render.Render(w, r, &sending)
 }
 
+// sendingState changes sending state: pauses, resumes or cancels it
+// POST /sending/{id}/pause|resume|cancel
+func (h *Handler) sendingState(to model.SendingState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, _ := logging.GetCtxLogger(r.Context())
+		logger := h.Logger(ctx)
+
+		uid, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		sending, err := h.st.UpdateSendingState(ctx, uid, to)
+		if err != nil {
+			switch {
+			case errors.Is(err, pkg.ErrNotExists):
+				render.Render(w, r, ErrNotFound)
+			case errors.Is(err, pkg.ErrInvalidTransition):
+				render.Render(w, r, ErrConflict(err))
+			default:
+				logger.Err(err).Msg("sendingState st.UpdateSendingState")
+				render.Render(w, r, ErrServerError(err))
+			}
+			return
+		}
+
+		logger.Info().Msgf("sending state: %s", sending.State)
+
+		h.snd.NewSending(ctx, sending)
+
+		render.Render(w, r, &sending)
+	}
+}
+
+// sendingPreview renders the sending text for a client
+// GET /sending/{id}/preview/{clientId}
+func (h *Handler) sendingPreview(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	uid, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	clientID, err := uuid.Parse(chi.URLParam(r, "clientId"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	sending, err := h.st.GetSendingByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, pkg.ErrNotExists) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("sendingPreview st.GetSendingByID")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	client, err := h.st.GetClientByID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, pkg.ErrNotExists) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		logger.Err(err).Msg("sendingPreview st.GetClientByID")
+		render.Render(w, r, ErrServerError(err))
+		return
+	}
+
+	text, err := model.RenderText(sending.Text, client, time.Now())
+	if err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	render.Render(w, r, &model.TextPreview{
+		SendingID: sending.ID,
+		ClientID:  client.ID,
+		Text:      text,
+	})
+}
+
 // sendingDelete deletes sending
 // DELETE /sending/{id}/
 func (h *Handler) sendingDelete(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"noty/pkg/fakeprobe"
+	"noty/pkg/logging"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// fakeprobe serves a local fake of the probe server send API (see probe.yml).
+// Point the sender at it with SENDER_ADDRESS=http://localhost:8081/v1.
+func main() {
+	log.Logger = log.
+		Output(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.Stamp,
+		})
+
+	if err := run(); err != nil {
+		log.Fatal().Err(err).Msg("can't start fakeprobe")
+	}
+	os.Exit(0)
+}
+
+func run() error {
+	ctx, logger := logging.GetCtxLogger(context.Background())
+
+	cfg := fakeprobe.NewDefaultConfig()
+	flag.StringVar(&cfg.Address, "a", cfg.Address, "listen address")
+	flag.StringVar(&cfg.Secret, "secret", "", "HS256 secret to verify tokens with (empty: signature not checked)")
+	flag.BoolVar(&cfg.CheckExpiry, "check-exp", false, "reject expired tokens")
+	flag.DurationVar(&cfg.Latency, "latency", 0, "minimal response delay")
+	flag.DurationVar(&cfg.LatencyJitter, "jitter", 0, "random delay added to latency")
+	flag.Float64Var(&cfg.ErrorRate, "error-rate", 0, "share of requests answered with 500")
+	flag.Float64Var(&cfg.MalformedRate, "malformed-rate", 0, "share of requests answered with a malformed body")
+	flag.DurationVar(&cfg.DowntimeEvery, "down-every", 0, "downtime period")
+	flag.DurationVar(&cfg.DowntimeFor, "down-for", 0, "downtime duration within each period")
+	flag.Int64Var(&cfg.Seed, "seed", 0, "random seed (0: time based)")
+	issue := flag.String("issue-token", "", "print a token for the given name signed with -secret and exit")
+	flag.Parse()
+
+	if *issue != "" {
+		token, err := fakeprobe.NewToken(cfg.Secret, *issue, 365*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		os.Stdout.WriteString(token + "\n")
+		return nil
+	}
+
+	h, err := fakeprobe.NewHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	srv := &http.Server{Addr: cfg.Address, Handler: h}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info().Msgf("fakeprobe listening on %s", cfg.Address)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
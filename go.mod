@@ -13,6 +13,7 @@ require (
 	github.com/rs/zerolog v1.26.1
 	github.com/swaggo/http-swagger v1.2.6
 	github.com/swaggo/http-swagger/example/go-chi v0.0.0-20220611072802-7af1c17f1a0f
+	github.com/swaggo/swag v1.8.1
 )
 
 require (
@@ -32,7 +33,6 @@ require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.6 // indirect
 	github.com/swaggo/files v0.0.0-20210815190702-a29dd2bc99b2 // indirect
-	github.com/swaggo/swag v1.8.1 // indirect
 	golang.org/x/crypto v0.0.0-20211215165025-cf75a172585e // indirect
 	golang.org/x/net v0.0.0-20220425223048-2871e0cb64e4 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AudienceMode defines when clients of a sending are selected.
+type AudienceMode string
+
+const (
+	// AudienceModeFrozen - the audience is selected once when the sending starts,
+	// clients added or changed later don't join or leave it.
+	AudienceModeFrozen AudienceMode = "FROZEN"
+	// AudienceModeDynamic - the audience is re-selected on every pass, clients
+	// matching the filter later join it.
+	AudienceModeDynamic AudienceMode = "DYNAMIC"
+)
+
+var (
+	// audienceModeToIntMap maps AudienceMode value to its int representation.
+	audienceModeToIntMap = map[AudienceMode]int{
+		AudienceModeFrozen:  1,
+		AudienceModeDynamic: 2,
+	}
+
+	// audienceModeToStrMap maps AudienceMode value to its string representation.
+	audienceModeToStrMap = map[int]AudienceMode{
+		1: AudienceModeFrozen,
+		2: AudienceModeDynamic,
+	}
+)
+
+// NewAudienceModeFromInt returns AudienceMode by its int representation (might be invalid).
+func NewAudienceModeFromInt(v int) AudienceMode {
+	return audienceModeToStrMap[v]
+}
+
+// String implements the fmt.Stringer interface.
+func (m AudienceMode) String() string {
+	return string(m)
+}
+
+// Int returns enum value int representation.
+func (m AudienceMode) Int() int {
+	return audienceModeToIntMap[m]
+}
+
+// Validate validates enum value.
+func (m AudienceMode) Validate() error {
+	_, found := audienceModeToIntMap[m]
+	if !found {
+		return fmt.Errorf("unknown value: %v", m)
+	}
+
+	return nil
+}
+
+const (
+	defaultAudienceSampleSize = 10
+	maxAudienceSampleSize     = 100
+)
+
+type (
+	// AudienceRequest asks for the audience of a filter without creating a sending.
+	AudienceRequest struct {
+		Filter Filter `json:"filter"`
+		// Limit and Offset page the sample of matched clients.
+		Limit  int `json:"limit,omitempty"`
+		Offset int `json:"offset,omitempty"`
+	}
+
+	// Audience describes clients matched by a filter.
+	Audience struct {
+		Total    int            `json:"total"`
+		ByOpCode map[int]int    `json:"by_op_code"`
+		ByTag    map[string]int `json:"by_tag"`
+		ByTZ     map[string]int `json:"by_tz"`
+		// Sample is a page of matched clients ordered by phone.
+		Sample Clients `json:"sample"`
+	}
+)
+
+func (a *AudienceRequest) Bind(r *http.Request) error {
+	if err := a.Filter.Validate(); err != nil {
+		return err
+	}
+
+	if a.Limit == 0 {
+		a.Limit = defaultAudienceSampleSize
+	}
+	if a.Limit < 0 || a.Limit > maxAudienceSampleSize {
+		return fmt.Errorf("limit: must be between 1 and %d", maxAudienceSampleSize)
+	}
+	if a.Offset < 0 {
+		return fmt.Errorf("offset: must not be negative")
+	}
+
+	return nil
+}
+
+func (*Audience) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewAudience creates an empty Audience.
+func NewAudience() Audience {
+	return Audience{
+		ByOpCode: make(map[int]int),
+		ByTag:    make(map[string]int),
+		ByTZ:     make(map[string]int),
+		Sample:   Clients{},
+	}
+}
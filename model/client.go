@@ -6,6 +6,7 @@ import (
 	"github.com/rs/zerolog"
 	"net/http"
 	"noty/pkg/logging"
+	"time"
 )
 
 // Client keeps client data.
@@ -16,6 +17,8 @@ type (
 		OpCode int       `json:"op_code" yaml:"op_code"`
 		Tag    string    `json:"tag" yaml:"tag"`
 		TZ     string    `json:"tz" yaml:"tz"`
+		// Attrs are custom attributes available in message text templates.
+		Attrs map[string]string `json:"attrs,omitempty" yaml:"attrs"`
 	}
 	Clients []Client
 )
@@ -33,6 +36,10 @@ func (c *Client) Bind(r *http.Request) error {
 		return fmt.Errorf("phone is a required field")
 	}
 
+	if _, err := c.Location(); err != nil {
+		return err
+	}
+
 	//if c.OpCode == "" {
 	//	return fmt.Errorf("op_code is a required field")
 	//}
@@ -44,6 +51,17 @@ func (c *Client) Bind(r *http.Request) error {
 	return nil
 }
 
+// Location returns the client's time zone parsed as an IANA zone name.
+// Empty TZ means UTC.
+func (c *Client) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(c.TZ)
+	if err != nil {
+		return nil, fmt.Errorf("tz: %w", err)
+	}
+
+	return loc, nil
+}
+
 // GetLoggerContext enriches logger context with essential Client fields.
 func (c *Client) GetLoggerContext(logCtx zerolog.Context) zerolog.Context {
 	logCtx = logCtx.Int("phone", c.Phone)
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+type (
+	// MessageEvent is a message status transition, possibly caused by a delivery attempt.
+	MessageEvent struct {
+		ID         int64         `json:"id"`
+		MessageID  int64         `json:"message_id"`
+		CreatedAt  time.Time     `json:"created_at"`
+		FromStatus MessageStatus `json:"from_status,omitempty"`
+		ToStatus   MessageStatus `json:"to_status"`
+		Attempt    int           `json:"attempt,omitempty"`
+		HTTPStatus int           `json:"http_status,omitempty"`
+		Response   string        `json:"response,omitempty"`
+		LatencyMs  int64         `json:"latency_ms,omitempty"`
+		Error      string        `json:"error,omitempty"`
+	}
+
+	MessageEvents []*MessageEvent
+)
+
+func (MessageEvents) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -5,21 +5,37 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"net/http"
+	"noty/pkg"
 	"noty/pkg/logging"
 	"time"
 )
 
 type (
 	Message struct {
-		ID        int64         `json:"id" yaml:"id"`
-		CreatedAt time.Time     `json:"created_at" yaml:"created_at"`
-		Status    MessageStatus `json:"status" yaml:"status"`
-		SendingID uuid.UUID     `json:"sending_id" yaml:"sending_id"`
-		ClientID  uuid.UUID     `json:"client_id" yaml:"client_id"`
+		ID            int64           `json:"id" yaml:"id"`
+		CreatedAt     time.Time       `json:"created_at" yaml:"created_at"`
+		Status        MessageStatus   `json:"status" yaml:"status"`
+		SendingID     uuid.UUID       `json:"sending_id" yaml:"sending_id"`
+		ClientID      uuid.UUID       `json:"client_id" yaml:"client_id"`
+		Attempts      int             `json:"attempts" yaml:"attempts"`
+		NextAttemptAt time.Time       `json:"next_attempt_at" yaml:"next_attempt_at"`
+		LastError     string          `json:"last_error,omitempty" yaml:"last_error"`
+		LeaseOwner    string          `json:"lease_owner,omitempty" yaml:"lease_owner"`
+		Outcome       DeliveryOutcome `json:"outcome,omitempty" yaml:"outcome"`
+		// SentAt is when the provider accepted the message, CreatedAt never changes.
+		SentAt *time.Time `json:"sent_at,omitempty" yaml:"sent_at"`
 	}
 
 	Messages []*Message
 
+	// SendableMessage is a message claimed for sending along with its client.
+	SendableMessage struct {
+		Message Message
+		Client  Client
+	}
+
+	SendableMessages []*SendableMessage
+
 	MessageToSend struct {
 		ID    int64  `json:"id" yaml:"id"`
 		Phone int    `json:"phone" yaml:"phone"`
@@ -42,6 +58,16 @@ func (*Messages) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Transition changes the message status validating the transition.
+func (m *Message) Transition(to MessageStatus) error {
+	if !m.Status.CanTransitionTo(to) {
+		return fmt.Errorf("message status transition %s -> %s: %w", m.Status, to, pkg.ErrInvalidTransition)
+	}
+	m.Status = to
+
+	return nil
+}
+
 // GetLoggerContext enriches logger context with essential fields.
 func (m *Message) GetLoggerContext(logCtx zerolog.Context) zerolog.Context {
 	if m.ID != 0 {
@@ -51,26 +77,71 @@ func (m *Message) GetLoggerContext(logCtx zerolog.Context) zerolog.Context {
 	return logCtx
 }
 
+// Message status lifecycle:
+//
+//	NEW -> QUEUED -> SENDING -> SENT -> DELIVERED
+//	                        \-> NEW (retry later)
+//	                        \-> FAILED
+//
+// NEW and QUEUED messages may also become EXPIRED (stop_at passed) or
+// CANCELLED (sending cancelled). QUEUED messages whose text can't be rendered
+// are FAILED.
 const (
-	MessageStatusNew  MessageStatus = "NEW"
-	MessageStatusSent MessageStatus = "SENT"
+	MessageStatusNew       MessageStatus = "NEW"
+	MessageStatusSent      MessageStatus = "SENT"
+	MessageStatusFailed    MessageStatus = "FAILED"
+	MessageStatusExpired   MessageStatus = "EXPIRED"
+	MessageStatusQueued    MessageStatus = "QUEUED"
+	MessageStatusSending   MessageStatus = "SENDING"
+	MessageStatusCancelled MessageStatus = "CANCELLED"
+	MessageStatusDelivered MessageStatus = "DELIVERED"
 )
 
 var (
-	// messageStatusMap maps OrderStatus value to its int representation.
+	// messageStatusMap maps MessageStatus value to its int representation.
 	messageStatusToIntMap = map[MessageStatus]int{
-		MessageStatusNew:  1,
-		MessageStatusSent: 2,
+		MessageStatusNew:       1,
+		MessageStatusSent:      2,
+		MessageStatusFailed:    3,
+		MessageStatusExpired:   4,
+		MessageStatusQueued:    5,
+		MessageStatusSending:   6,
+		MessageStatusCancelled: 7,
+		MessageStatusDelivered: 8,
 	}
 
-	// messageStatusToStrMap maps OrderStatus value to its string representation.
+	// messageStatusToStrMap maps MessageStatus value to its string representation.
 	messageStatusToStrMap = map[int]MessageStatus{
 		1: MessageStatusNew,
 		2: MessageStatusSent,
+		3: MessageStatusFailed,
+		4: MessageStatusExpired,
+		5: MessageStatusQueued,
+		6: MessageStatusSending,
+		7: MessageStatusCancelled,
+		8: MessageStatusDelivered,
+	}
+
+	// messageStatusTransitions lists allowed target statuses per status.
+	messageStatusTransitions = map[MessageStatus][]MessageStatus{
+		MessageStatusNew:     {MessageStatusQueued, MessageStatusExpired, MessageStatusCancelled},
+		MessageStatusQueued:  {MessageStatusSending, MessageStatusNew, MessageStatusFailed, MessageStatusExpired, MessageStatusCancelled},
+		MessageStatusSending: {MessageStatusSent, MessageStatusNew, MessageStatusFailed, MessageStatusExpired},
+		MessageStatusSent:    {MessageStatusDelivered},
 	}
 )
 
-// NewMessageStatusFromInt returns OrderStatus by its int representation (might be invalid).
+// MessageStatuses returns all known message statuses.
+func MessageStatuses() []MessageStatus {
+	statuses := make([]MessageStatus, 0, len(messageStatusToStrMap))
+	for i := 1; i <= len(messageStatusToStrMap); i++ {
+		statuses = append(statuses, messageStatusToStrMap[i])
+	}
+
+	return statuses
+}
+
+// NewMessageStatusFromInt returns MessageStatus by its int representation (might be invalid).
 func NewMessageStatusFromInt(v int) MessageStatus {
 	return messageStatusToStrMap[v]
 }
@@ -94,3 +165,24 @@ func (s MessageStatus) Validate() error {
 
 	return nil
 }
+
+// CanTransitionTo reports whether the status may be changed to the given one.
+func (s MessageStatus) CanTransitionTo(to MessageStatus) bool {
+	for _, st := range messageStatusTransitions[s] {
+		if st == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsFinal reports whether the status is terminal for sending purposes.
+func (s MessageStatus) IsFinal() bool {
+	switch s {
+	case MessageStatusNew, MessageStatusQueued, MessageStatusSending:
+		return false
+	}
+
+	return true
+}
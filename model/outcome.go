@@ -0,0 +1,57 @@
+package model
+
+import "fmt"
+
+// DeliveryOutcome classifies the result of the last delivery attempt.
+type DeliveryOutcome string
+
+const (
+	// DeliveryOutcomeSuccess - the provider accepted the message.
+	DeliveryOutcomeSuccess DeliveryOutcome = "SUCCESS"
+	// DeliveryOutcomeRetryable - a temporary failure (5xx, timeout, 429), the message is retried.
+	DeliveryOutcomeRetryable DeliveryOutcome = "RETRYABLE"
+	// DeliveryOutcomePermanent - the provider rejected the message (4xx, bad response), no retries.
+	DeliveryOutcomePermanent DeliveryOutcome = "PERMANENT"
+)
+
+var (
+	// deliveryOutcomeToIntMap maps DeliveryOutcome value to its int representation.
+	deliveryOutcomeToIntMap = map[DeliveryOutcome]int{
+		DeliveryOutcomeSuccess:   1,
+		DeliveryOutcomeRetryable: 2,
+		DeliveryOutcomePermanent: 3,
+	}
+
+	// deliveryOutcomeToStrMap maps DeliveryOutcome value to its string representation.
+	deliveryOutcomeToStrMap = map[int]DeliveryOutcome{
+		1: DeliveryOutcomeSuccess,
+		2: DeliveryOutcomeRetryable,
+		3: DeliveryOutcomePermanent,
+	}
+)
+
+// NewDeliveryOutcomeFromInt returns DeliveryOutcome by its int representation
+// (empty for messages never attempted).
+func NewDeliveryOutcomeFromInt(v int) DeliveryOutcome {
+	return deliveryOutcomeToStrMap[v]
+}
+
+// String implements the fmt.Stringer interface.
+func (o DeliveryOutcome) String() string {
+	return string(o)
+}
+
+// Int returns enum value int representation.
+func (o DeliveryOutcome) Int() int {
+	return deliveryOutcomeToIntMap[o]
+}
+
+// Validate validates enum value.
+func (o DeliveryOutcome) Validate() error {
+	_, found := deliveryOutcomeToIntMap[o]
+	if !found {
+		return fmt.Errorf("unknown value: %v", o)
+	}
+
+	return nil
+}
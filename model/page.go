@@ -0,0 +1,260 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+var (
+	// ClientSortKeys are the sort keys of clients, the first one is the default.
+	ClientSortKeys = []string{"phone", "op_code", "tag", "tz"}
+	// SendingSortKeys are the sort keys of sendings, the first one is the default.
+	SendingSortKeys = []string{"start_at", "stop_at", "state"}
+	// MessageSortKeys are the sort keys of messages, the first one is the default.
+	MessageSortKeys = []string{"id", "created_at", "status", "next_attempt_at"}
+)
+
+type (
+	// Page selects a page of a list ordered by Sort: at most Limit items following
+	// the After cursor. Items with equal sort keys are ordered by ID.
+	Page struct {
+		Limit int
+		// After is the cursor of the last item of the previous page, nil for the first page.
+		After *Cursor
+		Sort  string
+		Desc  bool
+	}
+
+	// Cursor points at a list item by its sort key value and ID. It is passed
+	// to clients as an opaque string, see Cursor.String.
+	Cursor struct {
+		Key string `json:"k"`
+		ID  string `json:"id"`
+	}
+
+	// ClientQuery selects a page of clients matching the filter.
+	ClientQuery struct {
+		Page
+		Filter Filter
+	}
+
+	// SendingQuery selects a page of sendings in the states (any if empty)
+	// starting in [StartFrom, StartTo).
+	SendingQuery struct {
+		Page
+		States    []SendingState
+		StartFrom *time.Time
+		StartTo   *time.Time
+	}
+
+	// MessageQuery selects a page of messages in the statuses (any if empty)
+	// created in [CreatedFrom, CreatedTo).
+	MessageQuery struct {
+		Page
+		Statuses    []MessageStatus
+		CreatedFrom *time.Time
+		CreatedTo   *time.Time
+	}
+
+	ClientsPage struct {
+		Items Clients `json:"items"`
+		// Next is the cursor of the next page, empty on the last page.
+		Next string `json:"next,omitempty"`
+	}
+
+	SendingsStatusPage struct {
+		Items SendingsStatus `json:"items"`
+		Next  string         `json:"next,omitempty"`
+	}
+
+	MessagesPage struct {
+		Items Messages `json:"items"`
+		Next  string   `json:"next,omitempty"`
+	}
+)
+
+func (*ClientsPage) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (*SendingsStatusPage) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (*MessagesPage) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// String encodes the cursor as an opaque URL safe string.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor encoded with Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("after: invalid cursor")
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("after: invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// parse parses limit, after and sort ("-" prefixed for descending order) query parameters.
+func (p *Page) parse(values url.Values, sortKeys []string) error {
+	p.Limit = defaultPageLimit
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return fmt.Errorf("limit: must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = limit
+	}
+
+	if v := values.Get("after"); v != "" {
+		after, err := ParseCursor(v)
+		if err != nil {
+			return err
+		}
+		p.After = after
+	}
+
+	p.Sort = sortKeys[0]
+	if v := values.Get("sort"); v != "" {
+		p.Desc = strings.HasPrefix(v, "-")
+		p.Sort = strings.TrimPrefix(v, "-")
+		if !containsString(sortKeys, p.Sort) {
+			return fmt.Errorf("sort: must be one of %s", strings.Join(sortKeys, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ParseQuery parses the query, e.g. ?tag=vip&op_code=911&phone_prefix=7911&sort=-phone&limit=20.
+func (q *ClientQuery) ParseQuery(values url.Values) error {
+	if err := q.Page.parse(values, ClientSortKeys); err != nil {
+		return err
+	}
+
+	q.Filter = Filter{
+		Tags:          listParam(values, "tag"),
+		PhonePrefixes: listParam(values, "phone_prefix"),
+		TZs:           listParam(values, "tz"),
+	}
+	for _, v := range listParam(values, "op_code") {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("op_code: %w", err)
+		}
+		q.Filter.Codes = append(q.Filter.Codes, code)
+	}
+
+	return q.Filter.Validate()
+}
+
+// ParseQuery parses the query, e.g. ?state=RUNNING&start_from=2022-06-01T00:00:00Z&sort=-start_at.
+func (q *SendingQuery) ParseQuery(values url.Values) error {
+	if err := q.Page.parse(values, SendingSortKeys); err != nil {
+		return err
+	}
+
+	for _, v := range listParam(values, "state") {
+		state := SendingState(v)
+		if err := state.Validate(); err != nil {
+			return fmt.Errorf("state: %w", err)
+		}
+		q.States = append(q.States, state)
+	}
+
+	var err error
+	if q.StartFrom, err = timeParam(values, "start_from"); err != nil {
+		return err
+	}
+	if q.StartTo, err = timeParam(values, "start_to"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ParseQuery parses the query, e.g. ?status=FAILED&created_from=2022-06-01T00:00:00Z&sort=-created_at.
+func (q *MessageQuery) ParseQuery(values url.Values) error {
+	if err := q.Page.parse(values, MessageSortKeys); err != nil {
+		return err
+	}
+
+	for _, v := range listParam(values, "status") {
+		status := MessageStatus(v)
+		if err := status.Validate(); err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		q.Statuses = append(q.Statuses, status)
+	}
+
+	var err error
+	if q.CreatedFrom, err = timeParam(values, "created_from"); err != nil {
+		return err
+	}
+	if q.CreatedTo, err = timeParam(values, "created_to"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// listParam returns values of a repeated or comma separated query parameter.
+func listParam(values url.Values, name string) []string {
+	var list []string
+	for _, v := range values[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// timeParam parses an RFC 3339 query parameter, nil if it is missing.
+func timeParam(values url.Values, name string) (*time.Time, error) {
+	v := values.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &t, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
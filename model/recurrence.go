@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"noty/pkg/cron"
+	"time"
+)
+
+// Recurrence turns a sending into a template spawning a sending run at every
+// occurrence of the cron schedule between the template start_at and stop_at.
+// Each run has its own messages and statistics.
+type Recurrence struct {
+	// Cron is a 5-field cron expression, e.g. "0 10 * * 1" - Mondays at 10:00.
+	Cron string `json:"cron"`
+	// TZ is the time zone the schedule is evaluated in, UTC by default.
+	TZ string `json:"tz,omitempty"`
+	// Duration is how long each run lasts (its stop_at - start_at), in minutes.
+	Duration int `json:"duration"`
+}
+
+// Validate validates the recurrence rule.
+func (r Recurrence) Validate() error {
+	if _, err := cron.Parse(r.Cron); err != nil {
+		return fmt.Errorf("recurrence: %w", err)
+	}
+	if _, err := r.Location(); err != nil {
+		return fmt.Errorf("recurrence: tz: %w", err)
+	}
+	if r.Duration <= 0 {
+		return fmt.Errorf("recurrence: duration must be positive")
+	}
+
+	return nil
+}
+
+// Location returns the time zone of the schedule.
+func (r Recurrence) Location() (*time.Location, error) {
+	return time.LoadLocation(r.TZ)
+}
+
+// RunDuration returns how long each run lasts.
+func (r Recurrence) RunDuration() time.Duration {
+	return time.Duration(r.Duration) * time.Minute
+}
+
+// Next returns the first occurrence strictly after t.
+// Returns zero time if the schedule is invalid or never matches.
+func (r Recurrence) Next(t time.Time) time.Time {
+	schedule, err := cron.Parse(r.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := r.Location()
+	if err != nil {
+		return time.Time{}
+	}
+
+	return schedule.Next(t.In(loc))
+}
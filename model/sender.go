@@ -0,0 +1,38 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+type (
+	// BreakerState is the state of the circuit breaker around the send API.
+	BreakerState string
+
+	// BreakerStatus describes the circuit breaker.
+	BreakerStatus struct {
+		State    BreakerState `json:"state"`
+		Failures int          `json:"failures"`
+		OpenedAt *time.Time   `json:"opened_at,omitempty"`
+	}
+
+	// SenderStatus keeps sender service runtime status.
+	SenderStatus struct {
+		Breaker BreakerStatus `json:"breaker"`
+	}
+)
+
+const (
+	BreakerStateClosed   BreakerState = "closed"
+	BreakerStateOpen     BreakerState = "open"
+	BreakerStateHalfOpen BreakerState = "half-open"
+)
+
+func (*SenderStatus) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// String implements the fmt.Stringer interface.
+func (s BreakerState) String() string {
+	return string(s)
+}
@@ -8,14 +8,27 @@ import (
 	"github.com/rs/zerolog"
 	"net/http"
 	"noty/pkg/logging"
+	"strings"
 	"time"
 )
 
 // Sending keeps sending data.
 type (
+	// Filter selects clients of a sending. All predicates must match; an empty
+	// predicate matches any client, so an empty filter matches all clients.
 	Filter struct {
+		// Tags and Codes match clients having any of the tags / operator codes.
 		Tags  []string `json:"tags,omitempty"`
 		Codes []int    `json:"codes,omitempty"`
+		// ExcludeTags and ExcludeCodes match clients having none of the tags / operator codes.
+		ExcludeTags  []string `json:"exclude_tags,omitempty"`
+		ExcludeCodes []int    `json:"exclude_codes,omitempty"`
+		// PhonePrefixes match clients whose phone starts with any of the prefixes, e.g. "7916".
+		PhonePrefixes []string `json:"phone_prefixes,omitempty"`
+		// TZs match clients in any of the time zones.
+		TZs []string `json:"tzs,omitempty"`
+		// Attrs match clients having all the custom attributes with the given values.
+		Attrs map[string]string `json:"attrs,omitempty"`
 	}
 	Sending struct {
 		ID      uuid.UUID `json:"id"`
@@ -23,23 +36,103 @@ type (
 		Text    string    `json:"text"`
 		Filter  Filter    `json:"filter,omitempty"`
 		StopAt  time.Time `json:"stop_at,omitempty"`
+		Window  Window    `json:"window,omitempty"`
+		// State is managed by the service, it is ignored on input.
+		State SendingState `json:"state"`
+
+		// AudienceMode defines when clients are selected, FROZEN by default.
+		AudienceMode AudienceMode `json:"audience_mode"`
+		// SnapshotAt is when the frozen audience was selected.
+		SnapshotAt *time.Time `json:"snapshot_at,omitempty"`
+
+		// Recurrence makes the sending a template of recurring runs.
+		Recurrence *Recurrence `json:"recurrence,omitempty"`
+		// ParentID is the template the run was spawned from.
+		ParentID *uuid.UUID `json:"parent_id,omitempty"`
+		// NextRunAt is the next run of the template.
+		NextRunAt *time.Time `json:"next_run_at,omitempty"`
 	}
 	Sendings []*Sending
 
 	SendingStatus struct {
-		Sending *Sending `json:"sending"`
-		New     int      `json:"new"`
-		Sent    int      `json:"sent"`
+		Sending  *Sending              `json:"sending"`
+		Statuses map[MessageStatus]int `json:"statuses"`
+		// Audience is the size of the frozen audience snapshot or the number of clients
+		// currently matching the filter.
+		Audience *int `json:"audience"`
+		// SMS is the estimated segmentation of a single message.
+		SMS SMSSegmentation `json:"sms"`
+		// Segments is the estimated number of SMS segments of all messages, for cost estimation.
+		Segments int `json:"segments"`
 	}
 	SendingsStatus []*SendingStatus
 )
 
+// NewSendingStatus creates SendingStatus with zero counts for all message statuses.
+func NewSendingStatus(sending *Sending) *SendingStatus {
+	status := &SendingStatus{
+		Sending:  sending,
+		Statuses: make(map[MessageStatus]int),
+		SMS:      SegmentSMSText(sending.Text),
+	}
+	for _, st := range MessageStatuses() {
+		status.Statuses[st] = 0
+	}
+
+	return status
+}
+
+// SetCount sets the number of messages in the status and updates the segments estimate.
+func (s *SendingStatus) SetCount(status MessageStatus, count int) {
+	s.Segments += (count - s.Statuses[status]) * s.SMS.Segments
+	s.Statuses[status] = count
+}
+
+// SetSnapshotAudience sets the audience of a sending with a frozen audience snapshot:
+// the snapshot has a message per client. Returns false if there is no snapshot.
+func (s *SendingStatus) SetSnapshotAudience() bool {
+	if s.Sending.AudienceMode != AudienceModeFrozen || s.Sending.SnapshotAt == nil {
+		return false
+	}
+
+	audience := 0
+	for _, count := range s.Statuses {
+		audience += count
+	}
+	s.Audience = &audience
+
+	return true
+}
+
+// Validate validates the filter predicates.
+func (f Filter) Validate() error {
+	for _, prefix := range f.PhonePrefixes {
+		if prefix == "" || strings.Trim(prefix, "0123456789") != "" {
+			return fmt.Errorf("filter: phone prefix %q: digits expected", prefix)
+		}
+	}
+	for _, tz := range f.TZs {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("filter: tz: %w", err)
+		}
+	}
+	for key := range f.Attrs {
+		if key == "" {
+			return fmt.Errorf("filter: empty attribute name")
+		}
+	}
+
+	return nil
+}
+
 func (dst *Filter) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
 	if src == nil {
 		return errors.New("NULL values can't be decoded. Scan into a &*MyType to handle NULLs")
 	}
 
-	if err := (pgtype.CompositeFields{&dst.Tags, &dst.Codes}).DecodeBinary(ci, src); err != nil {
+	if err := (pgtype.CompositeFields{
+		&dst.Tags, &dst.Codes, &dst.ExcludeTags, &dst.ExcludeCodes, &dst.PhonePrefixes, &dst.TZs, &dst.Attrs,
+	}).DecodeBinary(ci, src); err != nil {
 		return err
 	}
 
@@ -47,19 +140,34 @@ func (dst *Filter) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
 }
 
 func (src Filter) EncodeBinary(ci *pgtype.ConnInfo, buf []byte) (newBuf []byte, err error) {
-	var a pgtype.TextArray
-	err = a.Set(src.Tags)
-	if err != nil {
-		return nil, err
+	var tags, excludeTags, prefixes, tzs pgtype.TextArray
+	var codes, excludeCodes pgtype.Int8Array
+	for _, f := range []struct {
+		dst interface{ Set(interface{}) error }
+		src interface{}
+	}{
+		{&tags, src.Tags},
+		{&codes, src.Codes},
+		{&excludeTags, src.ExcludeTags},
+		{&excludeCodes, src.ExcludeCodes},
+		{&prefixes, src.PhonePrefixes},
+		{&tzs, src.TZs},
+	} {
+		if err := f.dst.Set(f.src); err != nil {
+			return nil, err
+		}
 	}
 
-	var b pgtype.Int8Array
-	err = b.Set(src.Codes)
-	if err != nil {
-		return nil, err
+	attrs := pgtype.JSONB{Status: pgtype.Null}
+	if len(src.Attrs) > 0 {
+		if err := attrs.Set(src.Attrs); err != nil {
+			return nil, err
+		}
 	}
 
-	return (pgtype.CompositeFields{&a, &b}).EncodeBinary(ci, buf)
+	return (pgtype.CompositeFields{
+		&tags, &codes, &excludeTags, &excludeCodes, &prefixes, &tzs, &attrs,
+	}).EncodeBinary(ci, buf)
 }
 
 func (*Sending) Render(w http.ResponseWriter, r *http.Request) error {
@@ -70,6 +178,10 @@ func (Sendings) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func (*SendingStatus) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 func (*SendingsStatus) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
@@ -82,6 +194,12 @@ func (s *Sending) Bind(r *http.Request) error {
 	if s.Text == "" {
 		return fmt.Errorf("text is a required field")
 	}
+	if err := ValidateText(s.Text); err != nil {
+		return err
+	}
+	if err := ValidateSMSLength(s.Text); err != nil {
+		return err
+	}
 
 	if s.StartAt.IsZero() {
 		return fmt.Errorf("start_at is a required field")
@@ -89,9 +207,53 @@ func (s *Sending) Bind(r *http.Request) error {
 	if s.StopAt.IsZero() {
 		return fmt.Errorf("stop_at is a required field")
 	}
+	if err := s.Window.Validate(); err != nil {
+		return err
+	}
+	if err := s.Filter.Validate(); err != nil {
+		return err
+	}
+	// an omitted mode is FROZEN for a new sending and kept on update
+	if s.AudienceMode != "" {
+		if err := s.AudienceMode.Validate(); err != nil {
+			return fmt.Errorf("audience_mode: %w", err)
+		}
+	}
+	if s.Recurrence != nil {
+		if err := s.Recurrence.Validate(); err != nil {
+			return err
+		}
+	}
+	s.ParentID = nil
+	s.NextRunAt = nil
+	s.SnapshotAt = nil
+
 	return nil
 }
 
+// IsTemplate reports whether the sending spawns recurring runs instead of sending messages.
+func (s *Sending) IsTemplate() bool {
+	return s.Recurrence != nil
+}
+
+// NewRun builds the run of the template starting at the given occurrence.
+func (s *Sending) NewRun(startAt time.Time) Sending {
+	parentID := s.ID
+
+	return Sending{
+		ID:       uuid.New(),
+		StartAt:  startAt,
+		Text:     s.Text,
+		Filter:   s.Filter,
+		StopAt:   startAt.Add(s.Recurrence.RunDuration()),
+		Window:   s.Window,
+		State:    SendingStateScheduled,
+		ParentID: &parentID,
+
+		AudienceMode: s.AudienceMode,
+	}
+}
+
 // GetLoggerContext enriches logger context with essential fields.
 func (s *Sending) GetLoggerContext(logCtx zerolog.Context) zerolog.Context {
 	if s.ID != uuid.Nil {
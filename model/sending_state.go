@@ -0,0 +1,104 @@
+package model
+
+import "fmt"
+
+// SendingState is the lifecycle state of a sending.
+//
+//	SCHEDULED -> RUNNING -> COMPLETED
+//	     \          |
+//	      \-> PAUSED -> SCHEDULED (resumed)
+//
+// Any not finished sending may be CANCELLED.
+type SendingState string
+
+const (
+	// SendingStateScheduled - the sending waits for its start_at.
+	SendingStateScheduled SendingState = "SCHEDULED"
+	// SendingStateRunning - messages of the sending are being sent.
+	SendingStateRunning SendingState = "RUNNING"
+	// SendingStatePaused - no messages are sent until the sending is resumed.
+	SendingStatePaused SendingState = "PAUSED"
+	// SendingStateCompleted - all messages are sent or stop_at passed.
+	SendingStateCompleted SendingState = "COMPLETED"
+	// SendingStateCancelled - the sending was cancelled, unsent messages are CANCELLED.
+	SendingStateCancelled SendingState = "CANCELLED"
+)
+
+var (
+	// sendingStateToIntMap maps SendingState value to its int representation.
+	sendingStateToIntMap = map[SendingState]int{
+		SendingStateScheduled: 1,
+		SendingStateRunning:   2,
+		SendingStatePaused:    3,
+		SendingStateCompleted: 4,
+		SendingStateCancelled: 5,
+	}
+
+	// sendingStateToStrMap maps SendingState value to its string representation.
+	sendingStateToStrMap = map[int]SendingState{
+		1: SendingStateScheduled,
+		2: SendingStateRunning,
+		3: SendingStatePaused,
+		4: SendingStateCompleted,
+		5: SendingStateCancelled,
+	}
+
+	// sendingStateTransitions lists allowed target states per state.
+	sendingStateTransitions = map[SendingState][]SendingState{
+		SendingStateScheduled: {SendingStateRunning, SendingStatePaused, SendingStateCompleted, SendingStateCancelled},
+		SendingStateRunning:   {SendingStatePaused, SendingStateCompleted, SendingStateCancelled},
+		SendingStatePaused:    {SendingStateScheduled, SendingStateCompleted, SendingStateCancelled},
+	}
+)
+
+// SendingActiveStates returns states in which messages of a sending are sent.
+func SendingActiveStates() []SendingState {
+	return []SendingState{SendingStateScheduled, SendingStateRunning}
+}
+
+// NewSendingStateFromInt returns SendingState by its int representation (might be invalid).
+func NewSendingStateFromInt(v int) SendingState {
+	return sendingStateToStrMap[v]
+}
+
+// String implements the fmt.Stringer interface.
+func (s SendingState) String() string {
+	return string(s)
+}
+
+// Int returns enum value int representation.
+func (s SendingState) Int() int {
+	return sendingStateToIntMap[s]
+}
+
+// Validate validates enum value.
+func (s SendingState) Validate() error {
+	_, found := sendingStateToIntMap[s]
+	if !found {
+		return fmt.Errorf("unknown value: %v", s)
+	}
+
+	return nil
+}
+
+// CanTransitionTo reports whether the state may be changed to the given one.
+func (s SendingState) CanTransitionTo(to SendingState) bool {
+	for _, st := range sendingStateTransitions[s] {
+		if st == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsActive reports whether messages of the sending may be sent.
+func (s SendingState) IsActive() bool {
+	for _, st := range SendingActiveStates() {
+		if st == s {
+			return true
+		}
+	}
+
+	return false
+}
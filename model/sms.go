@@ -0,0 +1,112 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// SMSEncoding is the encoding a message text is sent in.
+type SMSEncoding string
+
+const (
+	// SMSEncodingGSM7 - GSM 03.38 default alphabet, 160 chars per single SMS.
+	SMSEncodingGSM7 SMSEncoding = "GSM-7"
+	// SMSEncodingUCS2 - any other text (e.g. Cyrillic), 70 chars per single SMS.
+	SMSEncodingUCS2 SMSEncoding = "UCS-2"
+)
+
+const (
+	gsm7SingleLen = 160
+	gsm7PartLen   = 153 // concatenated SMS lose 7 septets to the UDH
+	ucs2SingleLen = 70
+	ucs2PartLen   = 67
+
+	// MaxSMSSegments limits the length of a sending text.
+	MaxSMSSegments = 10
+)
+
+const (
+	// gsm7Basic is the GSM 03.38 basic character set (without the escape).
+	gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	// gsm7Extension characters take two septets (escape + char).
+	gsm7Extension = "\f^{}\\[~]|€"
+)
+
+// SMSSegmentation describes how a text is split into SMS segments.
+type SMSSegmentation struct {
+	Encoding SMSEncoding `json:"encoding"`
+	// Length is the text length in encoding units: septets for GSM-7, UTF-16 code units for UCS-2.
+	Length   int `json:"length"`
+	Segments int `json:"segments"`
+}
+
+// SegmentSMS detects the text encoding and computes its SMS segment count.
+func SegmentSMS(text string) SMSSegmentation {
+	if septets, ok := gsm7Length(text); ok {
+		return SMSSegmentation{
+			Encoding: SMSEncodingGSM7,
+			Length:   septets,
+			Segments: segments(septets, gsm7SingleLen, gsm7PartLen),
+		}
+	}
+
+	units := len(utf16.Encode([]rune(text)))
+	return SMSSegmentation{
+		Encoding: SMSEncodingUCS2,
+		Length:   units,
+		Segments: segments(units, ucs2SingleLen, ucs2PartLen),
+	}
+}
+
+// SegmentSMSText estimates segmentation of a sending text template: placeholders are
+// rendered for a client without attributes, so real messages might be longer.
+func SegmentSMSText(text string) SMSSegmentation {
+	rendered, err := RenderText(text, Client{}, time.Now())
+	if err != nil {
+		rendered = text
+	}
+
+	return SegmentSMS(rendered)
+}
+
+// ValidateSMSLength rejects texts longer than MaxSMSSegments.
+func ValidateSMSLength(text string) error {
+	seg := SegmentSMSText(text)
+	if seg.Segments > MaxSMSSegments {
+		return fmt.Errorf("text: too long: %d %s segments, max %d", seg.Segments, seg.Encoding, MaxSMSSegments)
+	}
+
+	return nil
+}
+
+// gsm7Length returns the number of septets of the text, false if the text
+// can't be encoded in GSM-7.
+func gsm7Length(text string) (int, bool) {
+	septets := 0
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			septets++
+		case strings.ContainsRune(gsm7Extension, r):
+			septets += 2
+		default:
+			return 0, false
+		}
+	}
+
+	return septets, true
+}
+
+func segments(length, single, part int) int {
+	switch {
+	case length == 0:
+		return 0
+	case length <= single:
+		return 1
+	default:
+		return (length + part - 1) / part
+	}
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegmentSMS(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want SMSSegmentation
+	}{
+		{name: "empty", text: "", want: SMSSegmentation{Encoding: SMSEncodingGSM7}},
+		{name: "basic characters", text: "Hello, @£$¥èé!", want: SMSSegmentation{SMSEncodingGSM7, 14, 1}},
+		{name: "single gsm-7", text: strings.Repeat("a", 160), want: SMSSegmentation{SMSEncodingGSM7, 160, 1}},
+		{name: "gsm-7 over single", text: strings.Repeat("a", 161), want: SMSSegmentation{SMSEncodingGSM7, 161, 2}},
+		{name: "two gsm-7 parts", text: strings.Repeat("a", 306), want: SMSSegmentation{SMSEncodingGSM7, 306, 2}},
+		{name: "three gsm-7 parts", text: strings.Repeat("a", 307), want: SMSSegmentation{SMSEncodingGSM7, 307, 3}},
+		{name: "extension takes two septets", text: "a€[]", want: SMSSegmentation{SMSEncodingGSM7, 7, 1}},
+		{name: "single with extension", text: strings.Repeat("€", 80), want: SMSSegmentation{SMSEncodingGSM7, 160, 1}},
+		{name: "extension over single", text: strings.Repeat("€", 81), want: SMSSegmentation{SMSEncodingGSM7, 162, 2}},
+		{name: "not in gsm-7", text: "á", want: SMSSegmentation{SMSEncodingUCS2, 1, 1}},
+		{name: "cyrillic", text: "привет", want: SMSSegmentation{SMSEncodingUCS2, 6, 1}},
+		{name: "single ucs-2", text: strings.Repeat("я", 70), want: SMSSegmentation{SMSEncodingUCS2, 70, 1}},
+		{name: "ucs-2 over single", text: strings.Repeat("я", 71), want: SMSSegmentation{SMSEncodingUCS2, 71, 2}},
+		{name: "two ucs-2 parts", text: strings.Repeat("я", 134), want: SMSSegmentation{SMSEncodingUCS2, 134, 2}},
+		{name: "three ucs-2 parts", text: strings.Repeat("я", 135), want: SMSSegmentation{SMSEncodingUCS2, 135, 3}},
+		{name: "surrogate pair", text: "ok 😀", want: SMSSegmentation{SMSEncodingUCS2, 5, 1}},
+		{name: "mixed switches to ucs-2", text: strings.Repeat("a", 100) + "я", want: SMSSegmentation{SMSEncodingUCS2, 101, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SegmentSMS(tt.text); got != tt.want {
+				t.Errorf("SegmentSMS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSMSLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{name: "max gsm-7", text: strings.Repeat("a", MaxSMSSegments*gsm7PartLen)},
+		{name: "over max gsm-7", text: strings.Repeat("a", MaxSMSSegments*gsm7PartLen+1), wantErr: true},
+		{name: "max ucs-2", text: strings.Repeat("я", MaxSMSSegments*ucs2PartLen)},
+		{name: "over max ucs-2", text: strings.Repeat("я", MaxSMSSegments*ucs2PartLen+1), wantErr: true},
+		{name: "rendered template", text: "{{.Tag}}" + strings.Repeat("a", MaxSMSSegments*gsm7PartLen)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSMSLength(tt.text); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSMSLength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTextSize bounds a rendered text in bytes: a longer text exceeds MaxSMSSegments
+// in any encoding, as every character takes at most utf8.UTFMax bytes.
+const maxTextSize = MaxSMSSegments * gsm7PartLen * utf8.UTFMax
+
+var errTextTooLong = errors.New("rendered text too long")
+
+type (
+	// TextData is the data message text templates are rendered with, e.g.
+	// "Hi, {{.Attrs.name}}! Offer for {{.Tag}} until {{.LocalTime.Format "15:04"}}".
+	TextData struct {
+		Phone  int
+		OpCode int
+		Tag    string
+		TZ     string
+		// LocalTime is the current time in the client's time zone.
+		LocalTime time.Time
+		// Attrs are the client's custom attributes, missing ones render empty.
+		Attrs map[string]string
+	}
+
+	// TextPreview is the sending text rendered for a client.
+	TextPreview struct {
+		SendingID uuid.UUID `json:"sending_id"`
+		ClientID  uuid.UUID `json:"client_id"`
+		Text      string    `json:"text"`
+	}
+)
+
+func (*TextPreview) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewTextData builds template data of the client at the given time.
+func NewTextData(client Client, now time.Time) TextData {
+	loc, err := client.Location()
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return TextData{
+		Phone:     client.Phone,
+		OpCode:    client.OpCode,
+		Tag:       client.Tag,
+		TZ:        client.TZ,
+		LocalTime: now.In(loc),
+		Attrs:     client.Attrs,
+	}
+}
+
+// parseText parses the message text template. Actions which may run unbounded,
+// range loops and template calls (a template may call itself), are rejected.
+func parseText(text string) (*template.Template, error) {
+	tmpl, err := template.New("text").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("text: %w", err)
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkNode(t.Tree.Root); err != nil {
+			return nil, fmt.Errorf("text: %w", err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// checkNode rejects range and template actions within the node.
+func checkNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return errors.New("range is not allowed")
+	case *parse.TemplateNode:
+		return errors.New("template calls are not allowed")
+	}
+
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	if err := checkNode(n.List); err != nil {
+		return err
+	}
+
+	return checkNode(n.ElseList)
+}
+
+// executeText renders the template, failing once the text exceeds maxTextSize.
+func executeText(tmpl *template.Template, data TextData) (string, error) {
+	w := &limitedWriter{}
+	if err := tmpl.Execute(w, data); err != nil {
+		return "", fmt.Errorf("text: %w", err)
+	}
+
+	return w.b.String(), nil
+}
+
+// limitedWriter collects up to maxTextSize bytes and fails writes past it.
+type limitedWriter struct {
+	b strings.Builder
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.b.Len()+len(p) > maxTextSize {
+		return 0, errTextTooLong
+	}
+
+	return w.b.Write(p)
+}
+
+// ValidateText checks the text template parses and renders for a client without attributes.
+func ValidateText(text string) error {
+	tmpl, err := parseText(text)
+	if err != nil {
+		return err
+	}
+
+	_, err = executeText(tmpl, NewTextData(Client{}, time.Now()))
+
+	return err
+}
+
+// RenderText renders the message text template for the client.
+func RenderText(text string, client Client, now time.Time) (string, error) {
+	// plain text needs no rendering
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := parseText(text)
+	if err != nil {
+		return "", err
+	}
+
+	return executeText(tmpl, NewTextData(client, now))
+}
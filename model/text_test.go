@@ -0,0 +1,53 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderText(t *testing.T) {
+	client := Client{Phone: 79000000000, Tag: "vip", TZ: "Europe/Moscow", Attrs: map[string]string{"name": "Ann"}}
+	now := time.Date(2023, 5, 10, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		text string
+
+		want string
+		// wantErr is a substring of the error, empty if the text renders.
+		wantErr string
+	}{
+		{name: "plain text", text: "Hello", want: "Hello"},
+		{name: "fields", text: "Hi, {{.Attrs.name}} ({{.Tag}})", want: "Hi, Ann (vip)"},
+		{name: "missing attribute", text: "Hi{{.Attrs.surname}}", want: "Hi"},
+		{name: "local time", text: `{{.LocalTime.Format "15:04"}}`, want: "12:00"},
+		{name: "with", text: "{{with .Attrs.name}}Hi, {{.}}{{else}}Hi{{end}}", want: "Hi, Ann"},
+		{name: "syntax error", text: "{{.Tag", wantErr: "text:"},
+		{name: "range", text: "{{range 1000000000}}{{range 1000000000}}x{{end}}{{end}}", wantErr: "range is not allowed"},
+		{name: "range in if", text: "{{if .Tag}}{{else}}{{range .Attrs}}x{{end}}{{end}}", wantErr: "range is not allowed"},
+		{name: "recursive template", text: `{{define "a"}}x{{template "a"}}{{end}}{{template "a"}}`, wantErr: "template calls are not allowed"},
+		{name: "too long", text: strings.Repeat(`{{printf "%01000d" 0}}`, 10), wantErr: errTextTooLong.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderText(tt.text, client, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RenderText(%q) error = %v, want %q", tt.text, err, tt.wantErr)
+				}
+				if err := ValidateText(tt.text); err == nil {
+					t.Fatalf("ValidateText(%q) error = nil", tt.text)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderText(%q) error = %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const windowTimeLayout = "15:04"
+
+// Window is a daily time interval in the client's local time, e.g. 09:00-21:00.
+// A window with From after To wraps midnight (22:00-06:00).
+// The zero Window allows any time.
+type Window struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// IsZero reports whether the window is not set.
+func (w Window) IsZero() bool {
+	return w.From == "" && w.To == ""
+}
+
+// Validate validates the window bounds.
+func (w Window) Validate() error {
+	if w.IsZero() {
+		return nil
+	}
+
+	from, err := parseWindowTime(w.From)
+	if err != nil {
+		return fmt.Errorf("window from: %w", err)
+	}
+	to, err := parseWindowTime(w.To)
+	if err != nil {
+		return fmt.Errorf("window to: %w", err)
+	}
+	if from == to {
+		return fmt.Errorf("window: from and to must differ")
+	}
+
+	return nil
+}
+
+// Contains reports whether t (already in the client's location) is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if w.IsZero() {
+		return true
+	}
+
+	from, to := w.bounds()
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if from < to {
+		return now >= from && now < to
+	}
+
+	return now >= from || now < to
+}
+
+// NextOpening returns the nearest window opening after t in t's location.
+func (w Window) NextOpening(t time.Time) time.Time {
+	if w.IsZero() {
+		return t
+	}
+
+	from, _ := w.bounds()
+	y, m, d := t.Date()
+	opening := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(from)
+	if !opening.After(t) {
+		opening = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(from)
+	}
+
+	return opening
+}
+
+// bounds returns window bounds as offsets from midnight. The window must be valid.
+func (w Window) bounds() (time.Duration, time.Duration) {
+	from, _ := parseWindowTime(w.From)
+	to, _ := parseWindowTime(w.To)
+
+	return from, to
+}
+
+// parseWindowTime parses "HH:MM" into an offset from midnight.
+func parseWindowTime(v string) (time.Duration, error) {
+	t, err := time.Parse(windowTimeLayout, v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
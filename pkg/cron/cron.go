@@ -0,0 +1,210 @@
+// Package cron parses standard 5-field cron expressions
+// (minute hour day-of-month month day-of-week) and computes their occurrences.
+//
+// Fields support "*", numbers, ranges "a-b", lists "a,b" and steps "*/n", "a-b/n".
+// Day of week is 0-6 starting on Sunday, 7 is Sunday too. If both day of month
+// and day of week are restricted, a day matching either of them matches.
+// The macros @hourly, @daily, @weekly, @monthly and @yearly are supported as well.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxYears bounds the search for the next occurrence of schedules which never
+// match (e.g. February 30th).
+const maxYears = 5
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field bounds: minute, hour, day of month, month, day of week.
+var bounds = []struct{ min, max int }{
+	{0, 59},
+	{0, 23},
+	{1, 31},
+	{1, 12},
+	{0, 7},
+}
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar tell the day fields are not restricted.
+	domStar, dowStar bool
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(bounds) {
+		return nil, fmt.Errorf("cron %q: expected %d fields, got %d", expr, len(bounds), len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, f := range fields {
+		set, err := parseField(f, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: field %d: %w", expr, i+1, err)
+		}
+		sets[i] = set
+	}
+
+	s := &Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	// 7 is Sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps into a bit set.
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		from, to := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			ends := strings.SplitN(rng, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(ends[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if to, err = strconv.Atoi(ends[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			from = v
+			if step == 1 {
+				to = v
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first occurrence strictly after t, in t's location.
+// Returns zero time if the schedule never matches.
+//
+// Occurrences are wall clock times of the location, searched in UTC so that
+// DST transitions neither skip nor repeat them: a time falling into a spring
+// forward gap is moved forward by the gap (02:30 becomes 03:30), a time in
+// the hour repeated on fall back happens once, at its first pass.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	w := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	yearLimit := w.Year() + maxYears
+
+	for w.Year() <= yearLimit {
+		if !has(s.month, int(w.Month())) {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.hour, w.Hour()) {
+			w = w.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !has(s.minute, w.Minute()) {
+			w = w.Add(time.Minute)
+			continue
+		}
+
+		// The second pass of a repeated hour resolves to the first one, before t.
+		if next := inLocation(w, loc); next.After(t) {
+			return next
+		}
+		w = w.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
+
+// inLocation returns the instant of the wall clock time w, given in UTC, in loc.
+// time.Date does not define the instant of a time in a DST gap or a repeated hour,
+// so both offsets around w are tried: a time in a repeated hour resolves to its
+// first pass, a time in a gap is moved forward by the gap.
+func inLocation(w time.Time, loc *time.Location) time.Time {
+	_, before := w.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := w.Add(24 * time.Hour).In(loc).Zone()
+
+	first := w.Add(-time.Duration(before) * time.Second).In(loc)
+	if sameWall(first, w) {
+		return first
+	}
+	if second := w.Add(-time.Duration(after) * time.Second).In(loc); sameWall(second, w) {
+		return second
+	}
+
+	return first
+}
+
+func sameWall(t, w time.Time) bool {
+	return t.Day() == w.Day() && t.Hour() == w.Hour() && t.Minute() == w.Minute()
+}
+
+// dayMatches checks day of month and day of week fields.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
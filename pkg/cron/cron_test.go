@@ -0,0 +1,221 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		// wantErr is a substring of the error, empty if the expression is valid.
+		wantErr string
+	}{
+		{name: "all stars", expr: "* * * * *"},
+		{name: "lists ranges and steps", expr: "0,30 9-17 1-31/2 */3 1-5"},
+		{name: "sunday as 7", expr: "0 0 * * 7"},
+		{name: "surrounding spaces", expr: "  0 0 * * *  "},
+		{name: "macro", expr: "@weekly"},
+		{name: "too few fields", expr: "0 0 * *", wantErr: "expected 5 fields, got 4"},
+		{name: "too many fields", expr: "0 0 * * * *", wantErr: "expected 5 fields, got 6"},
+		{name: "empty", expr: "", wantErr: "expected 5 fields, got 0"},
+		{name: "unknown macro", expr: "@often", wantErr: "expected 5 fields, got 1"},
+		{name: "minute out of range", expr: "60 * * * *", wantErr: "field 1: \"60\" out of range 0-59"},
+		{name: "hour out of range", expr: "0 24 * * *", wantErr: "field 2: \"24\" out of range 0-23"},
+		{name: "day of month zero", expr: "0 0 0 * *", wantErr: "field 3: \"0\" out of range 1-31"},
+		{name: "month out of range", expr: "0 0 * 13 *", wantErr: "field 4: \"13\" out of range 1-12"},
+		{name: "day of week out of range", expr: "0 0 * * 8", wantErr: "field 5: \"8\" out of range 0-7"},
+		{name: "range end out of range", expr: "0 20-25 * * *", wantErr: "field 2: \"20-25\" out of range 0-23"},
+		{name: "reversed range", expr: "0 0 * * 5-1", wantErr: "field 5: \"5-1\" out of range 0-7"},
+		{name: "zero step", expr: "*/0 * * * *", wantErr: "field 1: invalid step \"*/0\""},
+		{name: "negative step", expr: "*/-5 * * * *", wantErr: "field 1: invalid step \"*/-5\""},
+		{name: "non-numeric step", expr: "*/x * * * *", wantErr: "field 1: invalid step \"*/x\""},
+		{name: "open range", expr: "0 9- * * *", wantErr: "field 2: invalid range \"9-\""},
+		{name: "negative value", expr: "-1 * * * *", wantErr: "field 1: invalid range \"-1\""},
+		{name: "non-numeric value", expr: "0 0 * JAN *", wantErr: "field 4: invalid value \"JAN\""},
+		{name: "empty list item", expr: "0, * * * *", wantErr: "field 1: invalid value \"\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Parse(%q) error = nil, want %q", tt.expr, tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Parse(%q) error = %q, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		// want are the occurrences following from, each computed from the previous one.
+		want []time.Time
+	}{
+		{
+			name: "every minute drops seconds",
+			expr: "* * * * *",
+			from: time.Date(2023, 5, 10, 12, 0, 30, 500, time.UTC),
+			want: []time.Time{utc(2023, 5, 10, 12, 1), utc(2023, 5, 10, 12, 2)},
+		},
+		{
+			name: "strictly after an occurrence",
+			expr: "0 12 * * *",
+			from: utc(2023, 5, 10, 12, 0),
+			want: []time.Time{utc(2023, 5, 11, 12, 0)},
+		},
+		{
+			name: "end of day rolls over the month and year",
+			expr: "*/20 * * * *",
+			from: utc(2023, 12, 31, 23, 45),
+			want: []time.Time{utc(2024, 1, 1, 0, 0), utc(2024, 1, 1, 0, 20)},
+		},
+		{
+			name: "31st skips short months",
+			expr: "0 0 31 * *",
+			from: utc(2023, 4, 30, 0, 0),
+			want: []time.Time{utc(2023, 5, 31, 0, 0), utc(2023, 7, 31, 0, 0), utc(2023, 8, 31, 0, 0)},
+		},
+		{
+			name: "30th skips february",
+			expr: "30 6 30 * *",
+			from: utc(2023, 1, 30, 7, 0),
+			want: []time.Time{utc(2023, 3, 30, 6, 30)},
+		},
+		{
+			name: "monthly macro on the first day",
+			expr: "@monthly",
+			from: utc(2023, 1, 31, 23, 59),
+			want: []time.Time{utc(2023, 2, 1, 0, 0), utc(2023, 3, 1, 0, 0)},
+		},
+		{
+			name: "february 29th waits for leap years",
+			expr: "0 0 29 2 *",
+			from: utc(2023, 3, 1, 0, 0),
+			want: []time.Time{utc(2024, 2, 29, 0, 0), utc(2028, 2, 29, 0, 0)},
+		},
+		{
+			name: "last days of february in a leap year",
+			expr: "0 9 28,29 2 *",
+			from: utc(2024, 2, 27, 10, 0),
+			want: []time.Time{utc(2024, 2, 28, 9, 0), utc(2024, 2, 29, 9, 0), utc(2025, 2, 28, 9, 0)},
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 13 * 5",
+			from: utc(2023, 10, 1, 0, 0),
+			// Fridays and the 13th: Oct 6, Oct 13 (a Friday), Oct 20
+			want: []time.Time{utc(2023, 10, 6, 0, 0), utc(2023, 10, 13, 0, 0), utc(2023, 10, 20, 0, 0)},
+		},
+		{
+			name: "day of week with any day of month",
+			expr: "0 8 * * 1-5",
+			from: utc(2023, 10, 6, 9, 0), // Friday
+			want: []time.Time{utc(2023, 10, 9, 8, 0)},
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			from: utc(2023, 10, 2, 0, 0), // Monday
+			want: []time.Time{utc(2023, 10, 8, 0, 0)},
+		},
+		{
+			name: "never matches",
+			expr: "0 0 30 2 *",
+			from: utc(2023, 1, 1, 0, 0),
+			want: []time.Time{{}},
+		},
+		{
+			name: "spring forward gap moves the occurrence forward",
+			expr: "30 2 * * *",
+			from: time.Date(2023, 3, 11, 3, 0, 0, 0, newYork),
+			// 02:30 does not exist on Mar 12, the clock jumps from 02:00 EST to 03:00 EDT.
+			want: []time.Time{
+				time.Date(2023, 3, 12, 7, 30, 0, 0, time.UTC).In(newYork), // 03:30 EDT
+				time.Date(2023, 3, 13, 6, 30, 0, 0, time.UTC).In(newYork), // 02:30 EDT
+			},
+		},
+		{
+			name: "spring forward gap keeps hourly occurrences",
+			expr: "0 * * * *",
+			from: time.Date(2023, 3, 26, 0, 30, 0, 0, berlin),
+			// The clock jumps from 02:00 CET to 03:00 CEST: 02:00 becomes 03:00, 03:00 happens once.
+			want: []time.Time{
+				time.Date(2023, 3, 26, 0, 0, 0, 0, time.UTC).In(berlin), // 01:00 CET
+				time.Date(2023, 3, 26, 1, 0, 0, 0, time.UTC).In(berlin), // 03:00 CEST
+				time.Date(2023, 3, 26, 2, 0, 0, 0, time.UTC).In(berlin), // 04:00 CEST
+			},
+		},
+		{
+			name: "fall back repeated hour runs once",
+			expr: "30 1 * * *",
+			from: time.Date(2023, 11, 5, 0, 0, 0, 0, newYork),
+			// 01:00-02:00 happens twice on Nov 5, first EDT then EST.
+			want: []time.Time{
+				time.Date(2023, 11, 5, 5, 30, 0, 0, time.UTC).In(newYork), // 01:30 EDT
+				time.Date(2023, 11, 6, 6, 30, 0, 0, time.UTC).In(newYork), // 01:30 EST
+			},
+		},
+		{
+			name: "fall back from the second pass of the repeated hour",
+			expr: "30 1 * * *",
+			from: time.Date(2023, 11, 5, 6, 10, 0, 0, time.UTC).In(newYork), // 01:10 EST
+			want: []time.Time{
+				time.Date(2023, 11, 6, 6, 30, 0, 0, time.UTC).In(newYork), // 01:30 EST
+			},
+		},
+		{
+			name: "fall back hourly occurrences follow the wall clock",
+			expr: "0 * * * *",
+			from: time.Date(2023, 10, 29, 0, 30, 0, 0, berlin),
+			// The clock goes back from 03:00 CEST to 02:00 CET.
+			want: []time.Time{
+				time.Date(2023, 10, 28, 23, 0, 0, 0, time.UTC).In(berlin), // 01:00 CEST
+				time.Date(2023, 10, 29, 0, 0, 0, 0, time.UTC).In(berlin),  // 02:00 CEST
+				time.Date(2023, 10, 29, 2, 0, 0, 0, time.UTC).In(berlin),  // 03:00 CET
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from := tt.from
+			for i, want := range tt.want {
+				got := s.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("occurrence %d: Next(%s) = %s, want %s", i+1, from, got, want)
+				}
+				if !got.IsZero() && got.Location() != from.Location() {
+					t.Fatalf("occurrence %d: location = %s, want %s", i+1, got.Location(), from.Location())
+				}
+				from = got
+			}
+		})
+	}
+}
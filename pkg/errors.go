@@ -9,4 +9,8 @@ var (
 	ErrNotExists       = errors.New("object not exists in the DB")
 	ErrServerError     = errors.New("internal server error")
 	ErrTooManyRequests = errors.New("too many requests")
+
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrLeaseLost         = errors.New("lease lost")
+	ErrSendingInactive   = errors.New("sending is not active")
 )
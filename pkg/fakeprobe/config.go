@@ -0,0 +1,64 @@
+package fakeprobe
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultAddress = "localhost:8081"
+)
+
+// Config defines the fake probe server behaviour.
+type Config struct {
+	// Address is the listen address of cmd/fakeprobe.
+	Address string
+
+	// Secret is the HS256 key tokens are verified with. Empty Secret accepts any
+	// well-formed token.
+	Secret string
+	// CheckExpiry rejects tokens with an "exp" claim in the past.
+	CheckExpiry bool
+
+	// Latency is the minimal response delay, LatencyJitter adds a random delay on top.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+
+	// ErrorRate is the share (0..1) of requests answered with 500.
+	ErrorRate float64
+	// MalformedRate is the share (0..1) of requests answered with 200 and a broken body.
+	MalformedRate float64
+
+	// DowntimeEvery and DowntimeFor define periodic downtime windows: the server
+	// drops connections during the first DowntimeFor of every DowntimeEvery period.
+	DowntimeEvery time.Duration
+	DowntimeFor   time.Duration
+
+	// Seed makes random failures reproducible, zero means a time based seed.
+	Seed int64
+}
+
+// validate performs a basic validation.
+func (c Config) validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("%s field: must be within [0, 1]", "error rate")
+	}
+	if c.MalformedRate < 0 || c.MalformedRate > 1 {
+		return fmt.Errorf("%s field: must be within [0, 1]", "malformed rate")
+	}
+	if c.Latency < 0 || c.LatencyJitter < 0 {
+		return fmt.Errorf("%s field: must not be negative", "latency")
+	}
+	if c.DowntimeFor > 0 && c.DowntimeEvery <= c.DowntimeFor {
+		return fmt.Errorf("%s field: period must be longer than downtime", "downtime")
+	}
+
+	return nil
+}
+
+// NewDefaultConfig builds a Config of a healthy server.
+func NewDefaultConfig() Config {
+	return Config{
+		Address: defaultAddress,
+	}
+}
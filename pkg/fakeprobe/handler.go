@@ -0,0 +1,194 @@
+// Package fakeprobe implements a local fake of the probe server send API (see probe.yml)
+// with configurable failure modes for development and integration tests.
+package fakeprobe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"noty/model"
+	"noty/pkg/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	serviceName = "fakeprobe"
+)
+
+type (
+	// Handler serves POST /send/{msgId} (also under /v1).
+	Handler struct {
+		*chi.Mux
+		cfg     Config
+		started time.Time
+
+		mu  sync.Mutex
+		rnd *rand.Rand
+	}
+
+	// apiResponse is the ApiResponse schema of probe.yml.
+	apiResponse struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// NewHandler creates the fake probe server handler.
+func NewHandler(cfg Config) (*Handler, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config validation: %w", err)
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	h := &Handler{
+		Mux:     chi.NewMux(),
+		cfg:     cfg,
+		started: time.Now(),
+		rnd:     rand.New(rand.NewSource(seed)),
+	}
+
+	h.Use(logging.LoggerMiddleware)
+	h.Post("/send/{msgId}", h.send)
+	h.Post("/v1/send/{msgId}", h.send)
+
+	return h, nil
+}
+
+// NewServer starts an httptest.Server with the fake probe handler.
+// The caller must Close it.
+func NewServer(cfg Config) (*httptest.Server, error) {
+	h, err := NewHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return httptest.NewServer(h), nil
+}
+
+// send
+// POST /send/{msgId}
+func (h *Handler) send(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := logging.GetCtxLogger(r.Context())
+	logger := h.Logger(ctx)
+
+	if h.down(time.Now()) {
+		logger.Info().Msg("downtime: dropping connection")
+		h.drop(w)
+		return
+	}
+
+	if !h.sleep(ctx) {
+		return
+	}
+
+	if err := h.cfg.verifyToken(r.Header.Get("Authorization")); err != nil {
+		logger.Info().Err(err).Msg("unauthorized")
+		writeJSON(w, http.StatusUnauthorized, apiResponse{Code: http.StatusUnauthorized, Message: err.Error()})
+		return
+	}
+
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "msgId"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiResponse{Code: http.StatusBadRequest, Message: "invalid msgId"})
+		return
+	}
+
+	var msg model.MessageToSend
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiResponse{Code: http.StatusBadRequest, Message: "invalid body"})
+		return
+	}
+	if msg.ID != msgID || msg.Phone == 0 || msg.Text == "" {
+		writeJSON(w, http.StatusBadRequest, apiResponse{Code: http.StatusBadRequest, Message: "invalid message"})
+		return
+	}
+
+	switch p := h.random(); {
+	case p < h.cfg.ErrorRate:
+		logger.Info().Int64("msg_id", msgID).Msg("simulated error")
+		writeJSON(w, http.StatusInternalServerError, apiResponse{Code: 1, Message: "internal error"})
+	case p < h.cfg.ErrorRate+h.cfg.MalformedRate:
+		logger.Info().Int64("msg_id", msgID).Msg("simulated malformed response")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"code": 0, "message": `))
+	default:
+		logger.Debug().Int64("msg_id", msgID).Msgf("message: %+v", msg)
+		writeJSON(w, http.StatusOK, apiResponse{Code: 0, Message: "OK"})
+	}
+}
+
+// down reports whether t falls into a downtime window.
+func (h *Handler) down(t time.Time) bool {
+	if h.cfg.DowntimeFor <= 0 {
+		return false
+	}
+
+	return t.Sub(h.started)%h.cfg.DowntimeEvery < h.cfg.DowntimeFor
+}
+
+// drop closes the client connection without a response.
+func (h *Handler) drop(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, apiResponse{Code: http.StatusServiceUnavailable, Message: "down"})
+		return
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+// sleep waits for the configured latency. Returns false if the request was cancelled.
+func (h *Handler) sleep(ctx context.Context) bool {
+	delay := h.cfg.Latency
+	if h.cfg.LatencyJitter > 0 {
+		delay += time.Duration(h.random() * float64(h.cfg.LatencyJitter))
+	}
+	if delay == 0 {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// random returns a pseudo-random number in [0, 1).
+func (h *Handler) random() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.rnd.Float64()
+}
+
+// Logger returns logger with service field set.
+func (h *Handler) Logger(ctx context.Context) *zerolog.Logger {
+	_, logger := logging.GetCtxLogger(ctx)
+	logger = logger.With().Str(logging.ServiceKey, serviceName).Logger()
+
+	return &logger
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
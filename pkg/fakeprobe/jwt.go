@@ -0,0 +1,103 @@
+package fakeprobe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenMissing   = errors.New("token: missing")
+	errTokenMalformed = errors.New("token: malformed")
+	errTokenSignature = errors.New("token: invalid signature")
+	errTokenExpired   = errors.New("token: expired")
+)
+
+type (
+	jwtHeader struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+	}
+
+	jwtClaims struct {
+		Exp  int64  `json:"exp,omitempty"`
+		Iss  string `json:"iss,omitempty"`
+		Name string `json:"name,omitempty"`
+	}
+)
+
+// NewToken issues an HS256 token like the ones of the real probe server.
+func NewToken(secret, name string, ttl time.Duration) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(jwtClaims{Exp: time.Now().Add(ttl).Unix(), Iss: "fakeprobe", Name: name})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encodeSegment(header) + "." + encodeSegment(claims)
+
+	return unsigned + "." + encodeSegment(sign(secret, unsigned)), nil
+}
+
+// verifyToken checks the bearer token of the Authorization header value.
+func (c Config) verifyToken(authorization string) error {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" || token == authorization {
+		return errTokenMissing
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errTokenMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return errTokenMalformed
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return errTokenMalformed
+	}
+
+	if c.Secret != "" {
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || !hmac.Equal(signature, sign(c.Secret, parts[0]+"."+parts[1])) {
+			return errTokenSignature
+		}
+	}
+
+	if c.CheckExpiry && claims.Exp != 0 && time.Now().Unix() > claims.Exp {
+		return errTokenExpired
+	}
+
+	return nil
+}
+
+func sign(secret, unsigned string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+
+	return mac.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("decoding segment: %w", err)
+	}
+
+	return json.Unmarshal(b, v)
+}
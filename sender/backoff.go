@@ -0,0 +1,22 @@
+package sender
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff returns a jittered exponential delay before the next attempt.
+// attempt is the number of attempts already made (starting from 1).
+func (c Config) backoff(attempt int) time.Duration {
+	delay := c.RetryBaseDelay
+	for i := 1; i < attempt && delay < c.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.RetryMaxDelay {
+		delay = c.RetryMaxDelay
+	}
+
+	// "Equal jitter": keep half of the delay, randomize the other half.
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,143 @@
+package sender
+
+import (
+	"noty/model"
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker around the provider calls.
+//
+// closed: calls are allowed, consecutive failures are counted;
+// open: calls are rejected until the open timeout passes;
+// half-open: a limited number of probe calls is allowed, a success closes
+// the breaker, a failure opens it again.
+type breaker struct {
+	mu sync.Mutex
+
+	threshold   int
+	openTimeout time.Duration
+	probes      int
+
+	state    model.BreakerState
+	failures int
+	openedAt time.Time
+	inFlight int
+
+	onChange func(from, to model.BreakerState)
+}
+
+func newBreaker(cfg Config, onChange func(from, to model.BreakerState)) *breaker {
+	return &breaker{
+		threshold:   cfg.BreakerThreshold,
+		openTimeout: cfg.BreakerOpenTimeout,
+		probes:      cfg.BreakerHalfOpenProbes,
+		state:       model.BreakerStateClosed,
+		onChange:    onChange,
+	}
+}
+
+// Allow reports whether a call may be made now.
+// Every allowed call must be followed by Success or Failure.
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case model.BreakerStateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(model.BreakerStateHalfOpen)
+		fallthrough
+	case model.BreakerStateHalfOpen:
+		if b.inFlight >= b.probes {
+			return false
+		}
+		b.inFlight++
+	}
+
+	return true
+}
+
+// Success records a successful call.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == model.BreakerStateHalfOpen {
+		b.release()
+		b.setState(model.BreakerStateClosed)
+	}
+}
+
+// Failure records a failed call.
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case model.BreakerStateHalfOpen:
+		b.release()
+		b.open()
+	case model.BreakerStateClosed:
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	}
+}
+
+// Release records a call whose outcome says nothing about the provider health
+// (e.g. cancelled by the caller).
+func (b *breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == model.BreakerStateHalfOpen {
+		b.release()
+	}
+}
+
+// Status returns the current breaker status.
+func (b *breaker) Status() model.BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := model.BreakerStatus{
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state != model.BreakerStateClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
+
+func (b *breaker) release() {
+	if b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+func (b *breaker) open() {
+	b.openedAt = time.Now()
+	b.setState(model.BreakerStateOpen)
+}
+
+func (b *breaker) setState(state model.BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if state != model.BreakerStateHalfOpen {
+		b.inFlight = 0
+	}
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
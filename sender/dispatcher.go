@@ -0,0 +1,294 @@
+package sender
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"noty/model"
+	"sync"
+	"time"
+)
+
+// job is a single message to be sent by a worker.
+type job struct {
+	sending model.Sending
+	client  model.Client
+	message model.Message
+
+	// text is the sending text rendered for the client, textErr the rendering error.
+	text    string
+	textErr error
+}
+
+// newJob builds the job rendering the sending text for the client.
+func newJob(sending model.Sending, client model.Client, message model.Message) job {
+	text, err := model.RenderText(sending.Text, client, time.Now())
+
+	return job{
+		sending: sending,
+		client:  client,
+		message: message,
+		text:    text,
+		textErr: err,
+	}
+}
+
+// dispatcher keeps a FIFO queue of jobs per sending and hands them out to
+// workers round-robin across sendings, so a large sending does not block
+// the others. Jobs to operators over their rate limit are skipped, so
+// a throttled operator does not hold back messages to the others.
+type dispatcher struct {
+	mu      sync.Mutex
+	queues  map[uuid.UUID][]job
+	pending map[uuid.UUID]int // queued and in-flight jobs per sending
+	more    map[uuid.UUID]model.Sending
+	order   []uuid.UUID
+	next    int
+	wake    chan struct{}
+	limiter *limiter
+
+	// onDrain is called when all jobs of a sending with more messages to claim are done.
+	onDrain func(sending model.Sending)
+}
+
+func newDispatcher(limiter *limiter, onDrain func(sending model.Sending)) *dispatcher {
+	return &dispatcher{
+		limiter: limiter,
+		queues:  make(map[uuid.UUID][]job),
+		pending: make(map[uuid.UUID]int),
+		more:    make(map[uuid.UUID]model.Sending),
+		wake:    make(chan struct{}, 1),
+		onDrain: onDrain,
+	}
+}
+
+// Add enqueues jobs of the sending. more tells that the sending has
+// further messages to claim once these jobs are done.
+func (d *dispatcher) Add(sending model.Sending, jobs []job, more bool) {
+	if len(jobs) == 0 {
+		return
+	}
+	sendingID := sending.ID
+
+	d.mu.Lock()
+	if more {
+		d.more[sendingID] = sending
+	}
+	if _, ok := d.queues[sendingID]; !ok {
+		d.order = append(d.order, sendingID)
+	}
+	d.queues[sendingID] = append(d.queues[sendingID], jobs...)
+	d.pending[sendingID] += len(jobs)
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Update replaces the sending of queued jobs, so edits take effect for
+// messages not sent yet.
+func (d *dispatcher) Update(sending model.Sending) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queue := d.queues[sending.ID]
+	for i := range queue {
+		queue[i] = newJob(sending, queue[i].client, queue[i].message)
+	}
+	if _, ok := d.more[sending.ID]; ok {
+		d.more[sending.ID] = sending
+	}
+}
+
+// Drop removes queued jobs of the sending and returns them.
+// Jobs already handed out to workers are not affected.
+func (d *dispatcher) Drop(sendingID uuid.UUID) []job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queue, ok := d.queues[sendingID]
+	if !ok {
+		return nil
+	}
+
+	delete(d.queues, sendingID)
+	delete(d.more, sendingID)
+	for i, id := range d.order {
+		if id == sendingID {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+
+	d.pending[sendingID] -= len(queue)
+	if d.pending[sendingID] <= 0 {
+		delete(d.pending, sendingID)
+	}
+
+	return queue
+}
+
+// Active reports whether the sending has queued or in-flight jobs.
+func (d *dispatcher) Active(sendingID uuid.UUID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.pending[sendingID] > 0
+}
+
+// Done marks a job handed out by Run as finished.
+func (d *dispatcher) Done(j job) {
+	d.mu.Lock()
+	id := j.sending.ID
+	d.pending[id]--
+	if d.pending[id] > 0 {
+		d.mu.Unlock()
+		return
+	}
+
+	delete(d.pending, id)
+	sending, more := d.more[id]
+	delete(d.more, id)
+	d.mu.Unlock()
+
+	if more && d.onDrain != nil {
+		d.onDrain(sending)
+	}
+}
+
+// pop takes the next job round-robin across sendings, skipping jobs to
+// operators over their rate limit. If no job may be sent now, pop returns
+// how long to wait for the rate limits (0 if there are no jobs at all).
+func (d *dispatcher) pop() (job, time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.order) == 0 {
+		return job{}, 0, false
+	}
+
+	now := time.Now()
+	if wait := d.limiter.globalDelay(now); wait > 0 {
+		return job{}, wait, false
+	}
+
+	var minWait time.Duration
+	for k := 0; k < len(d.order); k++ {
+		idx := (d.next + k) % len(d.order)
+		id := d.order[idx]
+		queue := d.queues[id]
+
+		for i, j := range queue {
+			if wait := d.limiter.operatorDelay(j.client.OpCode, now); wait > 0 {
+				if minWait == 0 || wait < minWait {
+					minWait = wait
+				}
+				continue
+			}
+			d.limiter.take(j.client.OpCode)
+
+			switch {
+			case len(queue) == 1:
+				delete(d.queues, id)
+				d.order = append(d.order[:idx], d.order[idx+1:]...)
+				d.next = idx
+			case i == 0:
+				d.queues[id] = queue[1:]
+				d.next = idx + 1
+			default:
+				d.queues[id] = append(queue[:i], queue[i+1:]...)
+				d.next = idx + 1
+			}
+
+			return j, 0, true
+		}
+	}
+
+	return job{}, minWait, false
+}
+
+// Run hands out jobs to out until ctx is done.
+func (d *dispatcher) Run(ctx context.Context, out chan<- job) {
+	for {
+		j, wait, ok := d.pop()
+		if !ok {
+			// Jobs are queued but rate limited: wait for a token or new jobs.
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			select {
+			case <-ctx.Done():
+			case <-d.wake:
+			case <-timeout:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- j:
+		}
+	}
+}
+
+// takeTokens takes up to n tokens of the shared bucket from the storage,
+// see storage.Storage.TakeRateTokens.
+type takeTokens func(ctx context.Context, key string, rate float64, burst, n int) (int, error)
+
+// SyncRates takes tokens of the shared rate limits until ctx is done.
+func (d *dispatcher) SyncRates(ctx context.Context, take takeTokens) {
+	d.mu.Lock()
+	buckets := d.limiter.sharedBuckets()
+	d.mu.Unlock()
+	if len(buckets) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rateSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		granted := false
+		for _, b := range buckets {
+			d.mu.Lock()
+			want := b.want()
+			d.mu.Unlock()
+			if want == 0 {
+				continue
+			}
+
+			// key, rate and burst never change, tokens are guarded by the mutex
+			n, err := take(ctx, b.key, b.rate, int(b.burst), want)
+			if err != nil || n == 0 {
+				continue
+			}
+			d.mu.Lock()
+			b.grant(n)
+			d.mu.Unlock()
+			granted = true
+		}
+		if granted {
+			select {
+			case d.wake <- struct{}{}:
+			default:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
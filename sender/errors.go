@@ -0,0 +1,96 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"noty/model"
+	"strconv"
+	"time"
+)
+
+// SendError is a failed send attempt classified by its outcome.
+type SendError struct {
+	Outcome model.DeliveryOutcome
+	// RetryAfter is the delay requested by the provider (429 Retry-After), if any.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Outcome, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// retryable wraps err as a retryable failure.
+func retryable(err error) *SendError {
+	return &SendError{Outcome: model.DeliveryOutcomeRetryable, Err: err}
+}
+
+// permanent wraps err as a permanent failure.
+func permanent(err error) *SendError {
+	return &SendError{Outcome: model.DeliveryOutcomePermanent, Err: err}
+}
+
+// outcomeOf classifies a Provider.Send error. Unclassified errors
+// (transport errors, timeouts) are retryable.
+func outcomeOf(err error) model.DeliveryOutcome {
+	if err == nil {
+		return model.DeliveryOutcomeSuccess
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.Outcome
+	}
+
+	return model.DeliveryOutcomeRetryable
+}
+
+// retryAfterOf returns the provider requested retry delay of a Provider.Send error.
+func retryAfterOf(err error) time.Duration {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.RetryAfter
+	}
+
+	return 0
+}
+
+// classifyStatus classifies a non-200 HTTP response of the provider.
+func classifyStatus(resp *http.Response) *SendError {
+	err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		sendErr := retryable(err)
+		sendErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return sendErr
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= http.StatusInternalServerError:
+		return retryable(err)
+	default:
+		return permanent(err)
+	}
+}
+
+// parseRetryAfter parses the Retry-After header: delay seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
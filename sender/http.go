@@ -0,0 +1,136 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"noty/model"
+	"noty/pkg/logging"
+	"time"
+)
+
+var _ Provider = (*httpProvider)(nil)
+
+const (
+	httpProviderName = "http-provider"
+
+	// maxResponseSize limits the provider response body kept for diagnostics.
+	maxResponseSize = 4096
+)
+
+type (
+	// httpProvider sends messages to the probe server API.
+	httpProvider struct {
+		client   *http.Client
+		endPoint string
+		token    string
+	}
+
+	// apiResponse is the ApiResponse schema of probe.yml.
+	apiResponse struct {
+		Code    *int   `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// NewHTTPProvider creates a new probe server API client.
+func NewHTTPProvider(cfg Config) *httpProvider {
+	return &httpProvider{
+		// The client timeout is a safety net, calls are bounded by ctx.
+		client:   &http.Client{Timeout: cfg.AttemptTimeout},
+		endPoint: cfg.endPoint(),
+		token:    cfg.Token,
+	}
+}
+
+// Send sends message to client.
+// POST https://probe.fbrq.cloud/v1/send/{{msgId}}
+func (p *httpProvider) Send(ctx context.Context, message model.MessageToSend) (Response, error) {
+	logger := p.Logger(ctx)
+	var response Response
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		logger.Err(err).Msg("failed to marshal message")
+		return response, permanent(fmt.Errorf("marshaling message: %w", err))
+	}
+
+	url := fmt.Sprintf("%s/%d", p.endPoint, message.ID)
+	logger.Debug().Msgf("url: %s", url)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Err(err).Msg("failed to create request")
+		return response, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.token))
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		response.Latency = time.Since(start)
+		if isTimeout(err) {
+			err = fmt.Errorf("timed out after %s: %w", response.Latency.Round(time.Millisecond), err)
+		}
+		logger.Err(err).Msg("failed to send request")
+		return response, retryable(fmt.Errorf("sending request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	response.StatusCode = resp.StatusCode
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	response.Latency = time.Since(start)
+	if err != nil {
+		logger.Err(err).Msg("failed to read response")
+		return response, retryable(fmt.Errorf("reading response: %w", err))
+	}
+	response.Body = string(body)
+	logger.Debug().Int("status", resp.StatusCode).Msgf("response: %s", response.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		sendErr := classifyStatus(resp)
+		logger.Err(sendErr).Int("status", resp.StatusCode).Msg("failed to send request")
+		return response, sendErr
+	}
+
+	// ApiResponse: {"code": int32, "message": string}, code 0 means success.
+	var apiResp apiResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil || apiResp.Code == nil {
+		sendErr := permanent(fmt.Errorf("malformed response: %q", response.Body))
+		logger.Err(sendErr).Msg("failed to parse response")
+		return response, sendErr
+	}
+	if *apiResp.Code != 0 {
+		sendErr := permanent(fmt.Errorf("rejected: code %d: %s", *apiResp.Code, apiResp.Message))
+		logger.Err(sendErr).Msg("message rejected")
+		return response, sendErr
+	}
+
+	return response, nil
+}
+
+// isTimeout reports whether the request failed because a deadline passed.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Logger returns logger with service field set.
+func (p *httpProvider) Logger(ctx context.Context) *zerolog.Logger {
+	_, logger := logging.GetCtxLogger(ctx)
+	logger = logger.With().Str(logging.ServiceKey, httpProviderName).Logger()
+
+	return &logger
+}
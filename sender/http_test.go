@@ -0,0 +1,165 @@
+package sender
+
+import (
+	"context"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"noty/model"
+	"noty/pkg/fakeprobe"
+	"noty/pkg/logging"
+	"testing"
+	"time"
+)
+
+func TestHTTPProviderSend(t *testing.T) {
+	reply := func(status int, header, value, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if header != "" {
+				w.Header().Set(header, value)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}
+	}
+	retryAt := time.Now().Add(2 * time.Minute).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name string
+		// probe configures the fake probe server, handler replaces it for replies
+		// the fake probe does not produce.
+		probe   fakeprobe.Config
+		handler http.HandlerFunc
+		text    string
+		// timeout bounds the call, zero means a second.
+		timeout time.Duration
+
+		wantOutcome model.DeliveryOutcome
+		wantStatus  int
+		// wantRetryAfter is the requested retry delay, HTTP dates have a precision of seconds.
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:        "accepted with code 0",
+			text:        "text",
+			wantOutcome: model.DeliveryOutcomeSuccess,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "200 with malformed body",
+			probe:       fakeprobe.Config{MalformedRate: 1},
+			text:        "text",
+			wantOutcome: model.DeliveryOutcomePermanent,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "200 without code",
+			handler:     reply(http.StatusOK, "", "", `{"message": "OK"}`),
+			wantOutcome: model.DeliveryOutcomePermanent,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "200 with non-zero code",
+			handler:     reply(http.StatusOK, "", "", `{"code": 3, "message": "invalid phone"}`),
+			wantOutcome: model.DeliveryOutcomePermanent,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "400 invalid message",
+			text:        "",
+			wantOutcome: model.DeliveryOutcomePermanent,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "401 invalid token",
+			probe:       fakeprobe.Config{Secret: "secret"},
+			text:        "text",
+			wantOutcome: model.DeliveryOutcomePermanent,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "500",
+			probe:       fakeprobe.Config{ErrorRate: 1},
+			text:        "text",
+			wantOutcome: model.DeliveryOutcomeRetryable,
+			wantStatus:  http.StatusInternalServerError,
+		},
+		{
+			name:        "503",
+			handler:     reply(http.StatusServiceUnavailable, "", "", ""),
+			wantOutcome: model.DeliveryOutcomeRetryable,
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		{
+			name:        "408",
+			handler:     reply(http.StatusRequestTimeout, "", "", ""),
+			wantOutcome: model.DeliveryOutcomeRetryable,
+			wantStatus:  http.StatusRequestTimeout,
+		},
+		{
+			name:           "429 with Retry-After seconds",
+			handler:        reply(http.StatusTooManyRequests, "Retry-After", "30", ""),
+			wantOutcome:    model.DeliveryOutcomeRetryable,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: 30 * time.Second,
+		},
+		{
+			name:           "429 with Retry-After date",
+			handler:        reply(http.StatusTooManyRequests, "Retry-After", retryAt, ""),
+			wantOutcome:    model.DeliveryOutcomeRetryable,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: 2 * time.Minute,
+		},
+		{
+			name:        "429 with invalid Retry-After",
+			handler:     reply(http.StatusTooManyRequests, "Retry-After", "soon", ""),
+			wantOutcome: model.DeliveryOutcomeRetryable,
+			wantStatus:  http.StatusTooManyRequests,
+		},
+		{
+			name:        "timeout",
+			probe:       fakeprobe.Config{Latency: time.Second},
+			text:        "text",
+			timeout:     50 * time.Millisecond,
+			wantOutcome: model.DeliveryOutcomeRetryable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *httptest.Server
+			if tt.handler != nil {
+				srv = httptest.NewServer(tt.handler)
+			} else {
+				var err error
+				if srv, err = fakeprobe.NewServer(tt.probe); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer srv.Close()
+
+			cfg := NewDefaultConfig()
+			cfg.Address = srv.URL
+			p := NewHTTPProvider(cfg)
+
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = time.Second
+			}
+			ctx, cancel := context.WithTimeout(logging.SetCtxLogger(context.Background(), zerolog.Nop()), timeout)
+			defer cancel()
+
+			resp, err := p.Send(ctx, model.MessageToSend{ID: 1, Phone: 79000000000, Text: tt.text})
+			if got := outcomeOf(err); got != tt.wantOutcome {
+				t.Fatalf("outcome = %s, want %s (err %v)", got, tt.wantOutcome, err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := retryAfterOf(err); got > tt.wantRetryAfter || got < tt.wantRetryAfter-2*time.Second {
+				t.Errorf("retry after = %s, want %s", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
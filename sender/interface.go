@@ -3,8 +3,27 @@ package sender
 import (
 	"context"
 	"noty/model"
+	"time"
 )
 
 type Service interface {
+	// NewSending notifies the service about a new or updated sending.
 	NewSending(ctx context.Context, sending model.Sending)
+
+	// Status returns the sender runtime status (circuit breaker state etc.).
+	Status(ctx context.Context) model.SenderStatus
+}
+
+// Provider delivers messages to the external send API.
+type Provider interface {
+	// Send sends a single message to the client.
+	// The Response is filled as far as the attempt got, even on error.
+	Send(ctx context.Context, message model.MessageToSend) (Response, error)
+}
+
+// Response describes the provider reply to a send attempt.
+type Response struct {
+	StatusCode int
+	Body       string
+	Latency    time.Duration
 }
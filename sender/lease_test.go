@@ -0,0 +1,226 @@
+package sender
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"noty/model"
+	"noty/pkg"
+	"noty/pkg/logging"
+	"noty/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseStorage keeps messages in memory with the lease semantics of storage.Storage.
+type leaseStorage struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	messages map[int64]*model.Message
+	expires  map[int64]time.Time
+}
+
+func newLeaseStorage() *leaseStorage {
+	return &leaseStorage{
+		messages: make(map[int64]*model.Message),
+		expires:  make(map[int64]time.Time),
+	}
+}
+
+// claim leases NEW messages to the owner and marks them QUEUED, like ClaimMessages.
+func (st *leaseStorage) claim(sendingID uuid.UUID, owner string, lease time.Duration, n int) model.Messages {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var messages model.Messages
+	for i := 1; i <= n; i++ {
+		m := &model.Message{
+			ID:         int64(i),
+			Status:     model.MessageStatusQueued,
+			SendingID:  sendingID,
+			ClientID:   uuid.New(),
+			LeaseOwner: owner,
+		}
+		st.messages[m.ID] = m
+		st.expires[m.ID] = time.Now().Add(lease)
+		claimed := *m
+		messages = append(messages, &claimed)
+	}
+
+	return messages
+}
+
+// failAbandoned fails SENDING messages with an expired lease, like FailAbandonedMessages
+// run by the sweep of any replica.
+func (st *leaseStorage) failAbandoned() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, m := range st.messages {
+		if m.Status == model.MessageStatusSending && st.expires[id].Before(time.Now()) {
+			m.Status = model.MessageStatusFailed
+			m.LeaseOwner = ""
+		}
+	}
+}
+
+func (st *leaseStorage) status(id int64) model.MessageStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.messages[id].Status
+}
+
+func (st *leaseStorage) AcquireMessage(ctx context.Context, message model.Message, lease time.Duration) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	m := st.messages[message.ID]
+	if m.Status != model.MessageStatusQueued || m.LeaseOwner != message.LeaseOwner ||
+		!st.expires[m.ID].After(time.Now()) {
+		return pkg.ErrLeaseLost
+	}
+	m.Status = model.MessageStatusSending
+	st.expires[m.ID] = time.Now().Add(lease)
+
+	return nil
+}
+
+func (st *leaseStorage) UpdateMessage(ctx context.Context, message model.Message) (model.Message, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	m := st.messages[message.ID]
+	if m.LeaseOwner != "" && m.LeaseOwner != message.LeaseOwner || m.Status.IsFinal() {
+		return model.Message{}, pkg.ErrLeaseLost
+	}
+	*m = message
+	m.LeaseOwner = ""
+	delete(st.expires, m.ID)
+
+	return *m, nil
+}
+
+func (st *leaseStorage) CreateMessageEvent(ctx context.Context, event model.MessageEvent) (model.MessageEvent, error) {
+	return event, nil
+}
+
+// leaseProvider succeeds after the delay, while the sweep runs on another replica.
+type leaseProvider struct {
+	st    *leaseStorage
+	delay time.Duration
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (p *leaseProvider) Send(ctx context.Context, message model.MessageToSend) (Response, error) {
+	time.Sleep(p.delay)
+	p.st.failAbandoned()
+
+	p.mu.Lock()
+	p.sent++
+	p.mu.Unlock()
+
+	return Response{StatusCode: 200}, nil
+}
+
+func (p *leaseProvider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.sent
+}
+
+func TestLeaseClaimThrottleSend(t *testing.T) {
+	tests := []struct {
+		name string
+		// lease is the claim lease, rate the global rate limit with burst 1:
+		// the second message waits 1/rate for the limiter. Both are far enough
+		// apart for a slow run not to change the outcome.
+		lease time.Duration
+		rate  float64
+		// delay is the provider call duration, the sweep runs at its end.
+		delay time.Duration
+
+		want     []model.MessageStatus
+		wantSent int
+	}{
+		{
+			name:     "lease renewed for a call outlasting the claim lease",
+			lease:    300 * time.Millisecond,
+			rate:     20,
+			delay:    500 * time.Millisecond,
+			want:     []model.MessageStatus{model.MessageStatusSent, model.MessageStatusSent},
+			wantSent: 2,
+		},
+		{
+			name:     "claim lease expired while throttled",
+			lease:    50 * time.Millisecond,
+			rate:     2,
+			want:     []model.MessageStatus{model.MessageStatusSent, model.MessageStatusQueued},
+			wantSent: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newLeaseStorage()
+			provider := &leaseProvider{st: st, delay: tt.delay}
+
+			cfg := NewDefaultConfig()
+			cfg.Workers = 2
+			cfg.MessageLease = tt.lease
+			cfg.RateLimit = tt.rate
+			cfg.RateBurst = 1
+			cfg.LocalRateLimits = true
+			svc, err := New(WithConfig(cfg), WithStorage(st), WithProvider(provider))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Workers log through the context logger instead of building their own.
+			ctx, cancel := context.WithCancel(logging.SetCtxLogger(context.Background(), zerolog.Nop()))
+			defer cancel()
+
+			jobs := make(chan job)
+			for i := 0; i < cfg.Workers; i++ {
+				go svc.worker(ctx, jobs)
+			}
+			go svc.dispatcher.Run(ctx, jobs)
+
+			sending := model.Sending{
+				ID:      uuid.New(),
+				StartAt: time.Now().Add(-time.Minute),
+				StopAt:  time.Now().Add(time.Hour),
+				Text:    "text",
+				State:   model.SendingStateRunning,
+			}
+			var claimed []job
+			for _, message := range st.claim(sending.ID, cfg.InstanceID, cfg.MessageLease, len(tt.want)) {
+				claimed = append(claimed, newJob(sending, model.Client{ID: message.ClientID, Phone: 79000000000}, *message))
+			}
+			svc.dispatcher.Add(sending, claimed, false)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for svc.dispatcher.Active(sending.ID) {
+				if time.Now().After(deadline) {
+					t.Fatal("messages not dispatched")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			st.failAbandoned()
+
+			for i, want := range tt.want {
+				if got := st.status(int64(i + 1)); got != want {
+					t.Errorf("message %d status = %s, want %s", i+1, got, want)
+				}
+			}
+			if sent := provider.count(); sent != tt.wantSent {
+				t.Errorf("sent = %d, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
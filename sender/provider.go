@@ -0,0 +1,17 @@
+package sender
+
+import (
+	"fmt"
+)
+
+// NewProvider builds the Provider selected by Config.Provider.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderHTTP:
+		return NewHTTPProvider(cfg), nil
+	case ProviderSimulate:
+		return NewSimulateProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,158 @@
+package sender
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// rateSyncInterval is how often shared buckets take tokens from the storage.
+const rateSyncInterval = 100 * time.Millisecond
+
+// bucket is a token bucket: tokens are added at rate per second up to burst,
+// every message takes one token.
+//
+// A shared bucket is kept in the storage for all replicas (see storage.Storage.TakeRateTokens),
+// the local one only holds tokens taken from it for the next sync interval.
+type bucket struct {
+	key    string
+	shared bool
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(key string, rate float64, burst int, shared bool) *bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	b := &bucket{
+		key:    key,
+		shared: shared,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+	if shared {
+		b.tokens = 0
+	}
+
+	return b
+}
+
+// refill adds tokens accumulated since the last call, shared buckets are refilled by grant.
+func (b *bucket) refill(now time.Time) {
+	if b.shared {
+		return
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// delay returns how long to wait until a token is available.
+func (b *bucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	if b.shared {
+		return rateSyncInterval
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// want returns how many tokens the shared bucket should take to cover the next sync interval.
+func (b *bucket) want() int {
+	need := math.Min(b.burst, math.Ceil(b.rate*rateSyncInterval.Seconds()))
+	if need < 1 {
+		need = 1
+	}
+
+	return int(math.Max(0, need-math.Floor(b.tokens)))
+}
+
+// grant adds tokens taken from the storage to the shared bucket.
+func (b *bucket) grant(n int) {
+	b.tokens += float64(n)
+}
+
+// take takes a token, delay must have returned 0 before.
+func (b *bucket) take() {
+	b.tokens--
+}
+
+// limiter limits the rate of outgoing messages globally and per operator code.
+// A nil bucket means no limit. Not safe for concurrent use: it is owned by
+// the dispatcher, which hands out jobs of all sendings.
+type limiter struct {
+	global    *bucket
+	operators map[int]*bucket
+}
+
+func newLimiter(cfg Config) *limiter {
+	shared := !cfg.LocalRateLimits
+	l := &limiter{
+		operators: make(map[int]*bucket, len(cfg.OperatorRateLimits)),
+	}
+	if cfg.RateLimit > 0 {
+		l.global = newBucket("global", cfg.RateLimit, cfg.RateBurst, shared)
+	}
+	for opCode, rate := range cfg.OperatorRateLimits {
+		if rate > 0 {
+			l.operators[opCode] = newBucket("op:"+strconv.Itoa(opCode), rate, cfg.RateBurst, shared)
+		}
+	}
+
+	return l
+}
+
+// sharedBuckets returns the buckets kept in the storage.
+func (l *limiter) sharedBuckets() []*bucket {
+	var buckets []*bucket
+	if l.global != nil && l.global.shared {
+		buckets = append(buckets, l.global)
+	}
+	for _, b := range l.operators {
+		if b.shared {
+			buckets = append(buckets, b)
+		}
+	}
+
+	return buckets
+}
+
+// globalDelay returns how long to wait until any message may be sent.
+func (l *limiter) globalDelay(now time.Time) time.Duration {
+	if l.global == nil {
+		return 0
+	}
+
+	return l.global.delay(now)
+}
+
+// operatorDelay returns how long to wait until a message to the operator may be sent.
+func (l *limiter) operatorDelay(opCode int, now time.Time) time.Duration {
+	b, ok := l.operators[opCode]
+	if !ok {
+		return 0
+	}
+
+	return b.delay(now)
+}
+
+// take accounts a message sent to the operator.
+func (l *limiter) take(opCode int) {
+	if l.global != nil {
+		l.global.take()
+	}
+	if b, ok := l.operators[opCode]; ok {
+		b.take()
+	}
+}
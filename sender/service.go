@@ -1,18 +1,17 @@
 package sender
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/jackc/pgx/v4"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
-	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"noty/model"
+	"noty/pkg"
 	"noty/pkg/logging"
 	"noty/storage"
+	"sync"
 	"time"
 )
 
@@ -20,14 +19,37 @@ var _ Service = (*service)(nil)
 
 const (
 	serviceName = "sender-service"
+
+	// breakerPollInterval is how often paused workers check the circuit breaker.
+	breakerPollInterval = time.Second
+
+	// maxSchedulerSleep bounds the scheduler sleep in case a notification is lost.
+	maxSchedulerSleep = time.Minute
+	// jobsBatchSize is the number of sending jobs claimed at once.
+	jobsBatchSize = 10
+	// jobLease is how long a claimed sending job stays locked.
+	jobLease = 5 * time.Minute
+
+	// refillQueueSize limits sendings waiting for the next batch of messages.
+	refillQueueSize = 100
+
+	// sweepInterval is how often all current sendings are re-processed (retries etc.).
+	sweepInterval = 60 * time.Second
+
+	// sendingLeaseMargin is added to the attempt timeout when the lease of a message
+	// is renewed for the provider call, it covers storing the outcome.
+	sendingLeaseMargin = time.Minute
 )
 
 type (
 	service struct {
-		Storage storage.Storage
-		inQueue chan model.Sending
-		client  *http.Client
-		config  Config
+		Storage    storage.Storage
+		Provider   Provider
+		wake       chan struct{}
+		refill     chan model.Sending
+		config     Config
+		breaker    *breaker
+		dispatcher *dispatcher
 	}
 
 	Option func(svc *service) error
@@ -49,27 +71,57 @@ func WithStorage(st storage.Storage) Option {
 	}
 }
 
+// WithProvider sets Provider.
+func WithProvider(p Provider) Option {
+	return func(svc *service) error {
+		svc.Provider = p
+		return nil
+	}
+}
+
 // New creates a new service.
 func New(opts ...Option) (*service, error) {
-	svc := &service{}
+	svc := &service{
+		config: NewDefaultConfig(),
+	}
 	for _, opt := range opts {
 		if err := opt(svc); err != nil {
 			return nil, fmt.Errorf("initialising dependencies: %w", err)
 		}
 	}
 
+	if err := svc.config.validate(); err != nil {
+		return nil, fmt.Errorf("config validation: %w", err)
+	}
+
 	if svc.Storage == nil {
 		return nil, fmt.Errorf("storage: nil")
 	}
-	//
-	//if svc.AccrualProvider == nil {
-	//	return nil, fmt.Errorf("AccrualProvider: nil")
-	//}
 
-	rand.Seed(time.Now().UnixNano())
-	svc.inQueue = make(chan model.Sending, 100)
+	if svc.Provider == nil {
+		p, err := NewProvider(svc.config)
+		if err != nil {
+			return nil, fmt.Errorf("building provider: %w", err)
+		}
+		svc.Provider = p
+	}
+
+	logger := svc.Logger(context.Background())
+	svc.breaker = newBreaker(svc.config, func(from, to model.BreakerState) {
+		logger.Warn().Msgf("circuit breaker: %s -> %s", from, to)
+	})
+
+	svc.refill = make(chan model.Sending, refillQueueSize)
+	svc.dispatcher = newDispatcher(newLimiter(svc.config), func(sending model.Sending) {
+		select {
+		case svc.refill <- sending:
+		default:
+			// picked up by the next sweep
+		}
+	})
 
-	svc.client = &http.Client{}
+	rand.Seed(time.Now().UnixNano())
+	svc.wake = make(chan struct{}, 1)
 
 	return svc, nil
 
@@ -78,19 +130,63 @@ func New(opts ...Option) (*service, error) {
 // Run starts service.
 func (svc *service) Run(ctx context.Context) error {
 	logger := svc.Logger(ctx)
-	logger.Info().Msg("started")
+	logger.Info().Int("workers", svc.config.Workers).Msg("started")
+
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < svc.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.worker(ctx, jobs)
+		}()
+	}
+	go svc.dispatcher.Run(ctx, jobs)
+	go svc.dispatcher.SyncRates(ctx, svc.takeRateTokens)
+
+	// Sendings inserted/updated by any replica wake the scheduler up.
+	// Without notifications it falls back to polling.
+	notifications, err := svc.Storage.ListenSendings(ctx)
+	if err != nil {
+		logger.Err(err).Msg("failed to listen for sendings, polling only")
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	sweepTicker := time.NewTicker(sweepInterval)
+	defer sweepTicker.Stop()
 
 	for {
+		// Pending jobs are picked up right away, also after a restart.
+		if err := svc.ProcessJobs(ctx); err != nil {
+			logger.Err(err).Msg("failed to process sending jobs")
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(svc.nextJobDelay(ctx))
+
 		select {
 		case <-ctx.Done():
+			wg.Wait()
 			logger.Info().Msg("stopped")
 			return nil
-		case sending := <-svc.inQueue:
-			err := svc.ProcessSending(ctx, sending)
-			if err != nil {
-				logger.Err(err).Msg("failed to process sending")
+		case <-svc.wake:
+		case id, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
 			}
-		case <-time.After(time.Second * 60):
+			logger.Debug().Str(logging.SendingIDKey, id.String()).Msg("sending changed")
+			svc.reloadSending(ctx, id)
+		case sending := <-svc.refill:
+			svc.refillSending(ctx, sending)
+		case <-timer.C:
+		case <-sweepTicker.C:
 			err := svc.ProcessSendings(ctx)
 			if err != nil {
 				logger.Err(err).Msg("failed to process sendings")
@@ -99,76 +195,506 @@ func (svc *service) Run(ctx context.Context) error {
 	}
 }
 
+// nextJobDelay returns how long the scheduler may sleep until the next sending job is due.
+func (svc *service) nextJobDelay(ctx context.Context) time.Duration {
+	logger := svc.Logger(ctx)
+
+	next, err := svc.Storage.NextSendingJobAt(ctx)
+	if err != nil {
+		if !errors.Is(err, pkg.ErrNoData) {
+			logger.Err(err).Msg("failed to get next sending job time")
+		}
+		return maxSchedulerSleep
+	}
+
+	delay := time.Until(next)
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxSchedulerSleep {
+		delay = maxSchedulerSleep
+	}
+
+	return delay
+}
+
+// ProcessJobs claims due sending jobs from the durable queue and processes them.
+func (svc *service) ProcessJobs(ctx context.Context) error {
+	logger := svc.Logger(ctx)
+
+	for ctx.Err() == nil {
+		sendings, err := svc.Storage.ClaimSendingJobs(ctx, jobsBatchSize, jobLease)
+		if err != nil {
+			return fmt.Errorf("claiming sending jobs: %w", err)
+		}
+		if len(sendings) == 0 {
+			return nil
+		}
+
+		for _, sending := range sendings {
+			if err := svc.ProcessSending(ctx, *sending); err != nil {
+				// The job lock expires and the job is claimed again.
+				logger.Err(err).Msg("failed to process sending")
+				continue
+			}
+			if err := svc.Storage.CompleteSendingJob(ctx, sending.ID); err != nil {
+				logger.Err(err).Msg("failed to complete sending job")
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProcessSending creates messages for clients matching the sending filter (for a dynamic
+// audience, a frozen one is stored when the sending starts), claims a batch of due
+// messages and enqueues them for dispatch.
 func (svc *service) ProcessSending(ctx context.Context, sending model.Sending) error {
-	//ctx, _ = logging.GetCtxLogger(ctx) // correlationID is created here
+	return svc.processSending(ctx, sending, true)
+}
+
+// processSending processes the sending, see ProcessSending. Refills after a drained batch
+// don't sync a dynamic audience: it is synced by the job pass and the sweep only.
+func (svc *service) processSending(ctx context.Context, sending model.Sending, syncAudience bool) error {
 	logger := svc.Logger(ctx)
+	logger.UpdateContext(sending.GetLoggerContext)
 
-	//logger.Info().Msgf("ProcessSending...")
+	if !sending.State.IsActive() {
+		svc.stopSending(ctx, sending)
+		return nil
+	}
 
 	if !svc.CheckTime(ctx, sending) {
 		return nil
 	}
 
-	clients, err := svc.Storage.FilterClients(ctx, sending.Filter)
-	if err != nil {
-		logger.Err(err).Msg("failed to filter clients")
-		return fmt.Errorf("filtering clients: %w", err)
-	}
-
-	//cl, _ := json.Marshal(clients)
-	//logger.Debug().Msgf("clients: %s", string(cl))
-
-	for _, client := range clients {
-		message, err := svc.Storage.GetMessageByClientAndSendingID(ctx, client.ID, sending.ID)
-		if err == pgx.ErrNoRows {
-			message, err = svc.Storage.CreateMessage(ctx,
-				model.Message{
-					Status:    model.MessageStatusNew,
-					SendingID: sending.ID,
-					ClientID:  client.ID,
-				})
-			if err != nil {
-				logger.Err(err).Msg("failed to create message")
-				continue
+	if sending.State == model.SendingStateScheduled {
+		started, err := svc.Storage.UpdateSendingState(ctx, sending.ID, model.SendingStateRunning)
+		if err != nil {
+			if errors.Is(err, pkg.ErrInvalidTransition) {
+				// paused or cancelled meanwhile
+				return nil
 			}
-		} else if err != nil {
-			logger.Err(err).Msg("failed to get message")
-			continue
+			logger.Err(err).Msg("failed to start sending")
+			return fmt.Errorf("starting sending: %w", err)
 		}
+		sending = started
+	}
 
-		if message.Status != model.MessageStatusNew {
-			continue
+	if sending.IsTemplate() {
+		return svc.processTemplate(ctx, sending)
+	}
+
+	// Messages of the sending are still being dispatched, they will be
+	// picked up again once the queue drains. The sending might have been
+	// edited meanwhile.
+	if svc.dispatcher.Active(sending.ID) {
+		svc.dispatcher.Update(sending)
+		return nil
+	}
+
+	if syncAudience && sending.AudienceMode == model.AudienceModeDynamic {
+		// Clients matching the filter since the last pass join the audience.
+		if _, err := svc.Storage.CreateSendingMessages(ctx, sending); err != nil {
+			logger.Err(err).Msg("failed to create messages")
+			return fmt.Errorf("creating messages: %w", err)
+		}
+		// Clients no longer matching it leave the audience.
+		if _, err := svc.Storage.CancelUnmatchedMessages(ctx, sending); err != nil {
+			logger.Err(err).Msg("failed to cancel unmatched messages")
+			return fmt.Errorf("cancelling unmatched messages: %w", err)
+		}
+	}
+
+	messages, err := svc.Storage.ClaimMessages(ctx, sending,
+		svc.config.InstanceID, svc.config.MessageLease, svc.config.ClaimBatchSize)
+	if err != nil {
+		logger.Err(err).Msg("failed to claim messages")
+		return fmt.Errorf("claiming messages: %w", err)
+	}
+
+	jobs := make([]job, 0, len(messages))
+	for _, m := range messages {
+		jobs = append(jobs, newJob(sending, m.Client, m.Message))
+	}
+
+	logger.Debug().Msgf("enqueued %d messages", len(jobs))
+	svc.dispatcher.Add(sending, jobs, len(messages) == svc.config.ClaimBatchSize)
+
+	return nil
+}
+
+// reloadSending applies a change of the sending to its messages queued on this replica,
+// so an edit or a pause takes effect right away, whichever replica processes its job.
+func (svc *service) reloadSending(ctx context.Context, id uuid.UUID) {
+	if !svc.dispatcher.Active(id) {
+		return
+	}
+
+	logger := svc.Logger(ctx)
+	sending, err := svc.Storage.GetSendingByID(ctx, id)
+	switch {
+	case errors.Is(err, pkg.ErrNotExists):
+		svc.dispatcher.Drop(id)
+	case err != nil:
+		// applied by the job pass of the change
+		logger.Err(err).Str(logging.SendingIDKey, id.String()).Msg("failed to get sending")
+	case !sending.State.IsActive():
+		svc.stopSending(ctx, sending)
+	default:
+		svc.dispatcher.Update(sending)
+	}
+}
+
+// refillSending claims the next batch of a sending whose queued messages are drained.
+// The sending is read again: it may have been paused or edited since it was queued,
+// possibly by another replica.
+func (svc *service) refillSending(ctx context.Context, sending model.Sending) {
+	logger := svc.Logger(ctx)
+	logger.UpdateContext(sending.GetLoggerContext)
+
+	sending, err := svc.Storage.GetSendingByID(ctx, sending.ID)
+	if err != nil {
+		// picked up by the next sweep
+		logger.Err(err).Msg("failed to get sending")
+		return
+	}
+
+	if err := svc.processSending(ctx, sending, false); err != nil {
+		logger.Err(err).Msg("failed to process sending")
+	}
+}
+
+// processTemplate spawns the due run of a recurring sending template and schedules
+// the template at its next occurrence. Runs start between the template start_at and
+// stop_at; runs missed while the service was down are skipped unless still in progress.
+func (svc *service) processTemplate(ctx context.Context, template model.Sending) error {
+	logger := svc.Logger(ctx)
+
+	rec := *template.Recurrence
+	now := time.Now()
+
+	var occurrence time.Time
+	if template.NextRunAt != nil {
+		occurrence = *template.NextRunAt
+	} else {
+		// start_at itself is an occurrence if it matches the schedule
+		occurrence = rec.Next(template.StartAt.Add(-time.Second))
+	}
+	if !occurrence.IsZero() && !occurrence.Add(rec.RunDuration()).After(now) {
+		occurrence = rec.Next(now.Add(-rec.RunDuration()))
+	}
+
+	var run *model.Sending
+	next := occurrence
+	if !occurrence.IsZero() && !occurrence.After(now) && occurrence.Before(template.StopAt) {
+		r := template.NewRun(occurrence)
+		run = &r
+		next = rec.Next(occurrence)
+	}
+	if !next.IsZero() && !next.Before(template.StopAt) {
+		next = time.Time{}
+	}
+
+	if err := svc.Storage.SpawnSendingRun(ctx, template, run, next); err != nil {
+		logger.Err(err).Msg("failed to spawn sending run")
+		return fmt.Errorf("spawning sending run: %w", err)
+	}
+	if next.IsZero() {
+		logger.Info().Msg("no more runs of recurring sending")
+	}
+
+	return nil
+}
+
+// stopSending drops queued messages of a paused, cancelled or completed sending.
+// Messages of a paused sending are given back to be sent once it is resumed,
+// messages of a cancelled sending are already cancelled by the storage.
+func (svc *service) stopSending(ctx context.Context, sending model.Sending) {
+	logger := svc.Logger(ctx)
+
+	jobs := svc.dispatcher.Drop(sending.ID)
+	if len(jobs) == 0 {
+		return
+	}
+	logger.Info().Msgf("sending %s, dropped %d queued messages", sending.State, len(jobs))
+
+	if sending.State != model.SendingStatePaused {
+		return
+	}
+	for _, j := range jobs {
+		message := j.message
+		svc.transition(ctx, &message, model.MessageStatusNew)
+	}
+}
+
+// worker sends messages handed out by the dispatcher until ctx is done.
+func (svc *service) worker(ctx context.Context, jobs <-chan job) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-jobs:
+			svc.sendMessage(ctx, j)
+			svc.dispatcher.Done(j)
 		}
+	}
+}
 
-		err = svc.SendMessage(ctx, model.MessageToSend{
-			ID:    message.ID,
-			Phone: client.Phone,
-			Text:  sending.Text,
+// sendMessage sends a single message and stores the outcome.
+func (svc *service) sendMessage(ctx context.Context, j job) {
+	logger := svc.Logger(ctx)
+	logger.UpdateContext(j.sending.GetLoggerContext)
+	logger.UpdateContext(j.client.GetLoggerContext)
+	logger.UpdateContext(j.message.GetLoggerContext)
+	ctx = logging.SetCtxLogger(ctx, *logger)
+
+	message := j.message
+	if !svc.CheckTime(ctx, j.sending) {
+		logger.Info().Msg("sending stop_at passed, message expired")
+		svc.transition(ctx, &message, model.MessageStatusExpired)
+		return
+	}
+
+	if deferred := svc.checkWindow(ctx, j, &message); deferred {
+		return
+	}
+
+	if j.textErr != nil {
+		// The text is validated when the sending is stored, rendering might
+		// still fail for a particular client.
+		logger.Err(j.textErr).Msg("failed to render message text")
+		message.LastError = j.textErr.Error()
+		message.Outcome = model.DeliveryOutcomePermanent
+		svc.record(ctx, &message, model.MessageEvent{
+			ToStatus: model.MessageStatusFailed,
+			Error:    j.textErr.Error(),
 		})
+		return
+	}
 
-		if err != nil {
-			logger.Err(err).Msgf("failed to send message: %v", message.ID)
-			continue
+	if !svc.waitBreaker(ctx) {
+		return
+	}
+
+	if err := svc.acquire(ctx, &message); err != nil {
+		svc.breaker.Release()
+		if errors.Is(err, pkg.ErrSendingInactive) {
+			// The sending was paused: give the message back until it is resumed.
+			logger.Info().Msg("sending paused, message released")
+			svc.transition(ctx, &message, model.MessageStatusNew)
 		}
+		return
+	}
 
-		message.Status = model.MessageStatusSent
-		message.CreatedAt = time.Now()
-		message, err = svc.Storage.UpdateMessage(ctx, message)
-		if err != nil {
-			logger.Err(err).Msgf("failed to update message: %v", message.ID)
-			continue
+	// Nothing may be delivered after stop_at: the provider call is bounded by it
+	// as well as by the attempt timeout and the service shutdown.
+	deadline := time.Now().Add(svc.config.AttemptTimeout)
+	if j.sending.StopAt.Before(deadline) {
+		deadline = j.sending.StopAt
+	}
+	sendCtx, cancel := context.WithDeadline(ctx, deadline)
+	resp, err := svc.Provider.Send(sendCtx, model.MessageToSend{
+		ID:    message.ID,
+		Phone: j.client.Phone,
+		Text:  j.text,
+	})
+	cancel()
+
+	message.Attempts++
+	message.Outcome = outcomeOf(err)
+	event := newAttemptEvent(message.Attempts, resp, err)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Interrupted by the shutdown, not the provider's fault: retry right away
+		// after restart or on another replica.
+		svc.breaker.Release()
+		logger.Warn().Err(err).Msg("message sending interrupted by shutdown")
+		message.Outcome = model.DeliveryOutcomeRetryable
+		message.LastError = err.Error()
+		message.NextAttemptAt = time.Now()
+		event.ToStatus = model.MessageStatusNew
+	case err != nil && !time.Now().Before(j.sending.StopAt):
+		svc.breaker.Release()
+		logger.Err(err).Msg("sending stop_at passed, message expired")
+		message.LastError = err.Error()
+		event.ToStatus = model.MessageStatusExpired
+	case message.Outcome == model.DeliveryOutcomePermanent:
+		// The provider is up but rejected the message: retrying won't help.
+		svc.breaker.Success()
+		logger.Err(err).Msgf("message rejected, attempt %d", message.Attempts)
+		message.LastError = err.Error()
+		event.ToStatus = model.MessageStatusFailed
+	case err != nil:
+		svc.breaker.Failure()
+		logger.Err(err).Msgf("failed to send message, attempt %d", message.Attempts)
+		event.ToStatus = svc.scheduleRetry(&message, err, j.sending.StopAt)
+	default:
+		svc.breaker.Success()
+		sentAt := time.Now()
+		message.SentAt = &sentAt
+		message.LastError = ""
+		event.ToStatus = model.MessageStatusSent
+	}
+
+	svc.record(ctx, &message, event)
+}
+
+// newAttemptEvent builds the event of a delivery attempt.
+func newAttemptEvent(attempt int, resp Response, sendErr error) model.MessageEvent {
+	event := model.MessageEvent{
+		Attempt:    attempt,
+		HTTPStatus: resp.StatusCode,
+		Response:   resp.Body,
+		LatencyMs:  resp.Latency.Milliseconds(),
+	}
+	if sendErr != nil {
+		event.Error = sendErr.Error()
+	}
+
+	return event
+}
+
+// checkWindow defers the message if the client's local time is outside the sending
+// delivery window: it is rescheduled for the next window opening or expired if the
+// opening is after stop_at. Returns true if the message was deferred.
+func (svc *service) checkWindow(ctx context.Context, j job, message *model.Message) bool {
+	logger := svc.Logger(ctx)
+
+	if j.sending.Window.IsZero() {
+		return false
+	}
+
+	loc, err := j.client.Location()
+	if err != nil {
+		logger.Warn().Err(err).Msg("invalid client time zone, using UTC")
+		loc = time.UTC
+	}
+
+	local := time.Now().In(loc)
+	if j.sending.Window.Contains(local) {
+		return false
+	}
+
+	opening := j.sending.Window.NextOpening(local)
+	if !opening.Before(j.sending.StopAt) {
+		logger.Info().Msg("client delivery window opens after stop_at, message expired")
+		svc.transition(ctx, message, model.MessageStatusExpired)
+		return true
+	}
+
+	logger.Debug().Msgf("client local time %s outside delivery window, deferred until %s",
+		local.Format(time.Kitchen), opening)
+	message.NextAttemptAt = opening
+	svc.transition(ctx, message, model.MessageStatusNew)
+
+	return true
+}
+
+// acquire moves the leased message to SENDING. The message must not be sent
+// if acquire fails: it may have been taken over by another replica, e.g. when
+// it was held back by the rate limiter past its claim lease.
+func (svc *service) acquire(ctx context.Context, message *model.Message) error {
+	logger := svc.Logger(ctx)
+
+	event := model.MessageEvent{
+		MessageID:  message.ID,
+		FromStatus: message.Status,
+		ToStatus:   model.MessageStatusSending,
+	}
+	acquired := *message
+	if err := acquired.Transition(model.MessageStatusSending); err != nil {
+		logger.Err(err).Msg("failed to change message status")
+		return err
+	}
+
+	if err := svc.Storage.AcquireMessage(ctx, acquired, svc.config.AttemptTimeout+sendingLeaseMargin); err != nil {
+		if !errors.Is(err, pkg.ErrSendingInactive) {
+			logger.Err(err).Msg("failed to acquire message")
 		}
+		return err
+	}
+	*message = acquired
+
+	if _, err := svc.Storage.CreateMessageEvent(ctx, event); err != nil {
+		logger.Err(err).Msg("failed to record message event")
+	}
+
+	return nil
+}
 
-		logger.Debug().Msgf("client: %+v", client)
-		logger.Debug().Msgf("message: %+v", message)
+// transition changes the message status, stores the message and records the transition.
+func (svc *service) transition(ctx context.Context, message *model.Message, to model.MessageStatus) error {
+	return svc.record(ctx, message, model.MessageEvent{ToStatus: to})
+}
+
+// record changes the message status to event.ToStatus, stores the message and the event.
+func (svc *service) record(ctx context.Context, message *model.Message, event model.MessageEvent) error {
+	logger := svc.Logger(ctx)
+
+	event.MessageID = message.ID
+	event.FromStatus = message.Status
+	if err := message.Transition(event.ToStatus); err != nil {
+		logger.Err(err).Msg("failed to change message status")
+		return err
+	}
+
+	// The outcome must be stored even if the service is shutting down,
+	// otherwise a sent message would be sent again.
+	storeCtx := logging.SetCtxLogger(context.Background(), *logger)
+	if _, err := svc.Storage.UpdateMessage(storeCtx, *message); err != nil {
+		logger.Err(err).Msg("failed to update message")
+		return err
+	}
+
+	if _, err := svc.Storage.CreateMessageEvent(storeCtx, event); err != nil {
+		logger.Err(err).Msg("failed to record message event")
 	}
 
+	logger.Debug().Msgf("message: %+v", message)
+
 	return nil
 }
 
+// takeRateTokens takes tokens of a rate limit shared by all replicas.
+func (svc *service) takeRateTokens(ctx context.Context, key string, rate float64, burst, n int) (int, error) {
+	taken, err := svc.Storage.TakeRateTokens(ctx, key, rate, burst, n)
+	if err != nil && ctx.Err() == nil {
+		svc.Logger(ctx).Err(err).Str("bucket", key).Msg("failed to take rate limit tokens")
+	}
+
+	return taken, err
+}
+
+// waitBreaker blocks while the circuit breaker is open.
+// Returns false if ctx is done before the breaker lets the call through.
+func (svc *service) waitBreaker(ctx context.Context) bool {
+	for !svc.breaker.Allow() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(breakerPollInterval):
+		}
+	}
+
+	return true
+}
+
 func (svc *service) ProcessSendings(ctx context.Context) error {
 	logger := svc.Logger(ctx)
 
+	if _, err := svc.Storage.ExpireMessages(ctx); err != nil {
+		logger.Err(err).Msg("failed to expire messages")
+	}
+
+	if _, err := svc.Storage.FailAbandonedMessages(ctx); err != nil {
+		logger.Err(err).Msg("failed to fail abandoned messages")
+	}
+
+	if _, err := svc.Storage.CompleteSendings(ctx); err != nil {
+		logger.Err(err).Msg("failed to complete sendings")
+	}
+
 	sendings, err := svc.Storage.FilterCurrentSendings(ctx)
 	if err != nil {
 		logger.Err(err).Msg("failed to filter sendings")
@@ -188,6 +714,27 @@ func (svc *service) ProcessSendings(ctx context.Context) error {
 	return nil
 }
 
+// scheduleRetry records a failed attempt and returns the status the message moves to:
+// NEW with the next attempt time (backoff or the provider Retry-After, whichever is longer)
+// or, once attempts are exhausted, FAILED.
+// A message whose next attempt would fall after stopAt is EXPIRED.
+func (svc *service) scheduleRetry(message *model.Message, sendErr error, stopAt time.Time) model.MessageStatus {
+	message.LastError = sendErr.Error()
+	if message.Attempts >= svc.config.MaxAttempts {
+		return model.MessageStatusFailed
+	}
+	delay := svc.config.backoff(message.Attempts)
+	if retryAfter := retryAfterOf(sendErr); retryAfter > delay {
+		delay = retryAfter
+	}
+	message.NextAttemptAt = time.Now().Add(delay)
+	if !message.NextAttemptAt.Before(stopAt) {
+		return model.MessageStatusExpired
+	}
+
+	return model.MessageStatusNew
+}
+
 // CheckTime checks if time is between start and end.
 func (svc *service) CheckTime(ctx context.Context, sending model.Sending) bool {
 	if sending.StartAt.Before(time.Now()) && sending.StopAt.After(time.Now()) {
@@ -196,23 +743,6 @@ func (svc *service) CheckTime(ctx context.Context, sending model.Sending) bool {
 	return false
 }
 
-// SendMessage sends message to client.
-func (svc *service) SendMessage(ctx context.Context, message model.MessageToSend) error {
-	logger := svc.Logger(ctx)
-
-	sendingDelay := 3
-
-	delay := rand.Intn(sendingDelay)
-	if delay == 2 {
-		return fmt.Errorf("cant send message")
-	}
-
-	logger.Info().Msgf("Sending message: %+v", message)
-	time.Sleep(time.Duration(delay) * time.Second)
-
-	return nil
-}
-
 // Logger returns logger with service field set.
 func (svc *service) Logger(ctx context.Context) *zerolog.Logger {
 	_, logger := logging.GetCtxLogger(ctx)
@@ -221,50 +751,18 @@ func (svc *service) Logger(ctx context.Context) *zerolog.Logger {
 	return &logger
 }
 
-func (svc *service) NewSending(ctx context.Context, sending model.Sending) {
-	svc.inQueue <- sending
-}
-
-// SendHTTP sends message to client.
-// POST https://probe.fbrq.cloud/v1/send/{{msgId}}
-func (svc *service) SendHTTP(ctx context.Context, message model.MessageToSend) error {
-	logger := svc.Logger(ctx)
-
-	jsonData, err := json.Marshal(message)
-	if err != nil {
-		logger.Err(err).Msg("failed to marshal message")
-		return fmt.Errorf("marshaling message: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/%d", svc.config.endPoint, message.ID)
-	logger.Debug().Msgf("url: %s", url)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.Err(err).Msg("failed to create request")
-		return fmt.Errorf("creating request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", svc.config.Token))
-
-	resp, err := svc.client.Do(req)
-	if err != nil {
-		logger.Err(err).Msg("failed to send request")
-		return fmt.Errorf("sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Err(err).Msg("failed to send request")
-		return fmt.Errorf("sending request: %w", err)
+// Status returns the sender runtime status.
+func (svc *service) Status(ctx context.Context) model.SenderStatus {
+	return model.SenderStatus{
+		Breaker: svc.breaker.Status(),
 	}
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Err(err).Msg("failed to read response")
-		return fmt.Errorf("reading response: %w", err)
+// NewSending wakes up the service to pick up the new or updated sending job.
+// The job itself is stored along with the sending, so NewSending never blocks.
+func (svc *service) NewSending(ctx context.Context, sending model.Sending) {
+	select {
+	case svc.wake <- struct{}{}:
+	default:
 	}
-	logger.Debug().Msgf("response: %s", string(body))
-
-	return nil
 }
@@ -0,0 +1,56 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog"
+	"math/rand"
+	"noty/model"
+	"noty/pkg/logging"
+	"time"
+)
+
+var _ Provider = (*simulateProvider)(nil)
+
+const (
+	simulateProviderName = "simulate-provider"
+)
+
+// simulateProvider pretends to send messages: it sleeps for a random delay
+// and fails randomly. Useful when the probe server is not reachable.
+type simulateProvider struct{}
+
+// NewSimulateProvider creates a new simulating provider.
+func NewSimulateProvider() *simulateProvider {
+	return &simulateProvider{}
+}
+
+// Send simulates sending message to client.
+func (p *simulateProvider) Send(ctx context.Context, message model.MessageToSend) (Response, error) {
+	logger := p.Logger(ctx)
+
+	sendingDelay := 3
+
+	delay := rand.Intn(sendingDelay)
+	if delay == 2 {
+		return Response{}, fmt.Errorf("cant send message")
+	}
+
+	logger.Info().Msgf("Sending message: %+v", message)
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return Response{Latency: time.Since(start)}, retryable(ctx.Err())
+	case <-time.After(time.Duration(delay) * time.Second):
+	}
+
+	return Response{Latency: time.Since(start)}, nil
+}
+
+// Logger returns logger with service field set.
+func (p *simulateProvider) Logger(ctx context.Context) *zerolog.Logger {
+	_, logger := logging.GetCtxLogger(ctx)
+	logger = logger.With().Str(logging.ServiceKey, simulateProviderName).Logger()
+
+	return &logger
+}
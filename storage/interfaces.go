@@ -5,6 +5,7 @@ import (
 	"github.com/google/uuid"
 	"io"
 	"noty/model"
+	"time"
 )
 
 // Storage defines models operations.
@@ -19,27 +20,114 @@ type Storage interface {
 
 	DeleteClientByID(ctx context.Context, id uuid.UUID) error
 
-	GetClients(ctx context.Context) (model.Clients, error)
+	// ListClients returns a page of clients matching the query filter.
+	// Returns pkg.ErrInvalidInput if the page cursor is malformed.
+	ListClients(ctx context.Context, query model.ClientQuery) (model.ClientsPage, error)
 
+	// GetClientByID returns the client. Returns pkg.ErrNotExists if there is no client.
+	GetClientByID(ctx context.Context, id uuid.UUID) (model.Client, error)
+
+	// FilterClients returns clients matching the filter, an empty filter matches all clients.
+	// Returns pkg.ErrNoData if no client matches.
 	FilterClients(ctx context.Context, filter model.Filter) (model.Clients, error)
 
+	// GetAudience returns the number of clients matching the filter broken down by
+	// operator code, tag and time zone, and a page of them ordered by phone.
+	GetAudience(ctx context.Context, filter model.Filter, limit, offset int) (model.Audience, error)
+
+	// CountAudience returns the number of clients matching the filter.
+	CountAudience(ctx context.Context, filter model.Filter) (int, error)
+
+	// CreateSending creates a new model.Sending and enqueues its job in the same transaction.
 	CreateSending(ctx context.Context, sending model.Sending) (model.Sending, error)
 
+	// UpdateSending updates model.Sending and reschedules its job in the same transaction.
 	UpdateSending(ctx context.Context, sending model.Sending) (model.Sending, error)
 
+	// UpdateSendingState changes the sending state. Starting a sending with a frozen audience
+	// creates messages for all matching clients, cancelling a sending cancels its unsent messages.
+	// Returns pkg.ErrInvalidTransition if the state can't be changed, pkg.ErrNotExists if there is no sending.
+	UpdateSendingState(ctx context.Context, id uuid.UUID, to model.SendingState) (model.Sending, error)
+
+	// SpawnSendingRun stores the run spawned by the recurring sending template (if any) and
+	// reschedules the template at next, zero next means no more runs. A run already spawned
+	// for the same start is skipped.
+	SpawnSendingRun(ctx context.Context, template model.Sending, run *model.Sending, next time.Time) error
+
+	// CompleteSendings marks finished sendings as completed. Returns the number of completed sendings.
+	CompleteSendings(ctx context.Context) (int64, error)
+
 	DeleteSendingByID(ctx context.Context, id uuid.UUID) error
 
-	GetSendings(ctx context.Context) (model.Sendings, error)
+	// GetSendingByID returns the sending. Returns pkg.ErrNotExists if there is no sending.
+	GetSendingByID(ctx context.Context, id uuid.UUID) (model.Sending, error)
+
+	// GetSendingStatus returns the sending with its message counts by status.
+	// Returns pkg.ErrNotExists if there is no sending.
+	GetSendingStatus(ctx context.Context, id uuid.UUID) (model.SendingStatus, error)
 
-	GetSendingsStatus(ctx context.Context) (model.SendingsStatus, error)
+	// ListSendingsStatus returns the status of a page of sendings matching the query.
+	// The audience is the frozen audience snapshot or the clients currently matching the filter.
+	// Returns pkg.ErrInvalidInput if the page cursor is malformed.
+	ListSendingsStatus(ctx context.Context, query model.SendingQuery) (model.SendingsStatusPage, error)
 
 	FilterCurrentSendings(ctx context.Context) (model.Sendings, error)
 
-	CreateMessage(ctx context.Context, message model.Message) (model.Message, error)
+	// ClaimSendingJobs locks up to limit due sending jobs for the lease duration
+	// and returns their sendings.
+	ClaimSendingJobs(ctx context.Context, limit int, lease time.Duration) (model.Sendings, error)
+
+	// CompleteSendingJob removes the processed sending job unless it was rescheduled meanwhile.
+	CompleteSendingJob(ctx context.Context, sendingID uuid.UUID) error
+
+	// NextSendingJobAt returns the time the next sending job becomes due.
+	// Returns pkg.ErrNoData if there are no jobs.
+	NextSendingJobAt(ctx context.Context) (time.Time, error)
 
+	// TakeRateTokens takes up to n tokens of the rate limit bucket shared by all replicas:
+	// the bucket is refilled at rate tokens per second up to burst. Returns the number of taken tokens.
+	TakeRateTokens(ctx context.Context, key string, rate float64, burst, n int) (int, error)
+
+	// ListenSendings subscribes to sendings inserts and updates made by any replica.
+	ListenSendings(ctx context.Context) (<-chan uuid.UUID, error)
+
+	// UpdateMessage updates model.Message and releases its lease.
+	// Returns pkg.ErrLeaseLost if the message is leased by another owner or finished meanwhile.
 	UpdateMessage(ctx context.Context, message model.Message) (model.Message, error)
 
-	GetMessagesBySendingID(ctx context.Context, sendingID uuid.UUID) (model.Messages, error)
+	// CreateSendingMessages creates NEW messages for all clients matching the sending filter
+	// which have none yet, in one statement. Returns the number of created messages.
+	CreateSendingMessages(ctx context.Context, sending model.Sending) (int64, error)
+
+	// CancelUnmatchedMessages cancels NEW messages of the sending whose clients no longer match
+	// its filter. Returns the number of cancelled messages.
+	CancelUnmatchedMessages(ctx context.Context, sending model.Sending) (int64, error)
+
+	// ClaimMessages leases up to limit due messages of the sending to the owner, marks them QUEUED
+	// and returns them with their clients. Messages leased by others are skipped until their lease
+	// expires, messages of a dynamic audience whose client no longer matches the filter are skipped.
+	// Nothing is claimed if the stored sending is no longer active.
+	ClaimMessages(ctx context.Context, sending model.Sending, owner string, lease time.Duration, limit int) (model.SendableMessages, error)
+
+	// AcquireMessage moves a QUEUED message leased by message.LeaseOwner to SENDING and renews
+	// the lease for the provider call. Returns pkg.ErrLeaseLost if the message was taken over or
+	// its lease expired, pkg.ErrSendingInactive if the sending is paused: it must not be sent then.
+	AcquireMessage(ctx context.Context, message model.Message, lease time.Duration) error
+
+	// FailAbandonedMessages fails SENDING messages whose lease expired.
+	FailAbandonedMessages(ctx context.Context) (int64, error)
+
+	// ExpireMessages marks unsent messages of sendings past their stop_at as expired
+	// and records the transitions. Returns the number of expired messages.
+	ExpireMessages(ctx context.Context) (int64, error)
+
+	// CreateMessageEvent stores a message status transition or delivery attempt.
+	CreateMessageEvent(ctx context.Context, event model.MessageEvent) (model.MessageEvent, error)
+
+	// GetMessageEvents returns events of the sending message ordered by time.
+	GetMessageEvents(ctx context.Context, sendingID uuid.UUID, messageID int64) (model.MessageEvents, error)
 
-	GetMessageByClientAndSendingID(ctx context.Context, clientID uuid.UUID, sendingID uuid.UUID) (model.Message, error)
+	// ListSendingMessages returns a page of messages of the sending matching the query.
+	// Returns pkg.ErrInvalidInput if the page cursor is malformed.
+	ListSendingMessages(ctx context.Context, sendingID uuid.UUID, query model.MessageQuery) (model.MessagesPage, error)
 }
@@ -5,17 +5,49 @@ import (
 	"errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"noty/model"
 	"noty/pkg"
+	"strconv"
+	"strings"
 )
 
+// clientColumns lists clients columns in the order scanClient expects them.
+const clientColumns = `clients.id, clients.phone, clients.op_code, clients.tag, clients.tz, clients.attrs`
+
+// scanClient scans a row selected with clientColumns (plus extra destinations).
+func scanClient(row pgx.Row, client *model.Client, dest ...interface{}) error {
+	return row.Scan(append(clientDest(client), dest...)...)
+}
+
+// clientDest returns scan destinations of clientColumns.
+func clientDest(client *model.Client) []interface{} {
+	return []interface{}{
+		&client.ID,
+		&client.Phone,
+		&client.OpCode,
+		&client.Tag,
+		&client.TZ,
+		&client.Attrs,
+	}
+}
+
+// clientAttrs returns attributes to store, never nil.
+func clientAttrs(client model.Client) map[string]string {
+	if client.Attrs == nil {
+		return map[string]string{}
+	}
+
+	return client.Attrs
+}
+
 func (svc *Storage) CreateClient(ctx context.Context, client model.Client) (model.Client, error) {
 	logger := svc.Logger(ctx)
 	logger.UpdateContext(client.GetLoggerContext)
 
 	_, err := svc.pool.Exec(ctx,
-		`insert into clients(id, phone, op_code, tag, tz) values ($1, $2, $3, $4, $5);`,
-		client.ID, client.Phone, client.OpCode, client.Tag, client.TZ)
+		`insert into clients(id, phone, op_code, tag, tz, attrs) values ($1, $2, $3, $4, $5, $6);`,
+		client.ID, client.Phone, client.OpCode, client.Tag, client.TZ, clientAttrs(client))
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -58,8 +90,8 @@ func (svc *Storage) UpdateClient(ctx context.Context, client model.Client) (mode
 	logger := svc.Logger(ctx)
 
 	res, err := svc.pool.Exec(ctx,
-		`UPDATE clients SET phone=$1, op_code=$2, tag=$3, tz=$4 WHERE id=$5;`,
-		client.Phone, client.OpCode, client.Tag, client.TZ, client.ID)
+		`UPDATE clients SET phone=$1, op_code=$2, tag=$3, tz=$4, attrs=$5 WHERE id=$6;`,
+		client.Phone, client.OpCode, client.Tag, client.TZ, clientAttrs(client), client.ID)
 	if err != nil {
 		logger.Err(err).Msg("UpdateClient")
 		return model.Client{}, err
@@ -75,53 +107,84 @@ func (svc *Storage) UpdateClient(ctx context.Context, client model.Client) (mode
 	return client, nil
 }
 
-func (svc *Storage) GetClients(ctx context.Context) (model.Clients, error) {
+// ListClients returns a page of clients matching the query filter.
+func (svc *Storage) ListClients(ctx context.Context, query model.ClientQuery) (model.ClientsPage, error) {
 	logger := svc.Logger(ctx)
-	var clients model.Clients
 
-	clientsRows, err := svc.pool.Query(
-		ctx,
-		"select * from clients ORDER BY phone ASC",
-	)
+	key, ok := clientSortColumns[query.Sort]
+	if !ok {
+		return model.ClientsPage{}, pkg.ErrInvalidInput
+	}
+
+	b := newWhereBuilder()
+	b.addFilter(query.Filter)
+	orderBy, limit := b.addPage(query.Page, key, clientIDColumn)
+
+	rows, err := svc.pool.Query(ctx,
+		"select "+clientColumns+", "+key.sortKey()+" from clients WHERE "+b.where()+orderBy+limit,
+		b.args...)
 	if err != nil {
-		logger.Err(err).Msg("GetClients")
-		return nil, err //pgx.ErrNoRows
+		logger.Err(err).Msg("ListClients")
+		return model.ClientsPage{}, pageError(err)
 	}
-	defer clientsRows.Close()
+	defer rows.Close()
 
-	for clientsRows.Next() {
-		client := model.Client{}
-		err := clientsRows.Scan(
-			&client.ID,
-			&client.Phone,
-			&client.OpCode,
-			&client.Tag,
-			&client.TZ,
-		)
-		if err != nil {
-			logger.Err(err).Msg("GetClients")
-			continue
+	page := model.ClientsPage{Items: model.Clients{}}
+	var keys []string
+	for rows.Next() {
+		var client model.Client
+		var sortKey string
+		if err := scanClient(rows, &client, &sortKey); err != nil {
+			logger.Err(err).Msg("ListClients")
+			return model.ClientsPage{}, err
 		}
-		clients = append(clients, client)
+		page.Items = append(page.Items, client)
+		keys = append(keys, sortKey)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Err(err).Msg("ListClients")
+		return model.ClientsPage{}, pageError(err)
 	}
 
-	if len(clients) == 0 {
-		return nil, pkg.ErrNoData
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		last := page.Items[query.Limit-1]
+		page.Next = model.Cursor{Key: keys[query.Limit-1], ID: last.ID.String()}.String()
 	}
 
-	return clients, nil
+	return page, nil
 }
 
+// GetClientByID returns the client. Returns pkg.ErrNotExists if there is no client.
+func (svc *Storage) GetClientByID(ctx context.Context, id uuid.UUID) (model.Client, error) {
+	logger := svc.Logger(ctx)
+
+	var client model.Client
+	err := scanClient(svc.pool.QueryRow(ctx,
+		"select "+clientColumns+" from clients WHERE id = $1", id), &client)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Client{}, pkg.ErrNotExists
+		}
+		logger.Err(err).Msg("GetClientByID")
+		return model.Client{}, err
+	}
+
+	return client, nil
+}
+
+// FilterClients returns clients matching the filter, see model.Filter for its semantics.
 func (svc *Storage) FilterClients(ctx context.Context, filter model.Filter) (model.Clients, error) {
 	logger := svc.Logger(ctx)
 	var clients model.Clients
 
-	// select * from clients where op_code in (911,912) AND tag in ('vip1','vip2');
+	// select * from clients where op_code = ANY('{911,912}') AND tag <> ALL('{vip1}') ...;
+	b := newWhereBuilder()
+	b.addFilter(filter)
 	clientsRows, err := svc.pool.Query(
 		ctx,
-		"select * from clients WHERE op_code = ANY($1::INT[]) AND tag = ANY($2::text[])",
-		filter.Codes,
-		filter.Tags,
+		"select "+clientColumns+" from clients WHERE "+b.where(),
+		b.args...,
 	)
 	if err != nil {
 		logger.Err(err).Msg("GetClients")
@@ -131,13 +194,7 @@ func (svc *Storage) FilterClients(ctx context.Context, filter model.Filter) (mod
 
 	for clientsRows.Next() {
 		client := model.Client{}
-		err := clientsRows.Scan(
-			&client.ID,
-			&client.Phone,
-			&client.OpCode,
-			&client.Tag,
-			&client.TZ,
-		)
+		err := scanClient(clientsRows, &client)
 		if err != nil {
 			logger.Err(err).Msg("GetClients")
 			continue
@@ -151,3 +208,133 @@ func (svc *Storage) FilterClients(ctx context.Context, filter model.Filter) (mod
 
 	return clients, nil
 }
+
+// GetAudience returns the number of clients matching the filter broken down by
+// operator code, tag and time zone, and a page of them ordered by phone.
+func (svc *Storage) GetAudience(ctx context.Context, filter model.Filter, limit, offset int) (model.Audience, error) {
+	logger := svc.Logger(ctx)
+	audience := model.NewAudience()
+
+	b := newWhereBuilder()
+	b.addFilter(filter)
+
+	rows, err := svc.pool.Query(ctx,
+		`select grouping(op_code), grouping(tag), grouping(tz),
+			coalesce(op_code, 0), coalesce(tag, ''), coalesce(tz, ''), count(*)
+		from clients WHERE `+b.where()+`
+		group by grouping sets ((op_code), (tag), (tz), ())`,
+		b.args...)
+	if err != nil {
+		logger.Err(err).Msg("GetAudience")
+		return model.Audience{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var byOpCode, byTag, byTZ int32
+		var opCode, count int
+		var tag, tz string
+		if err := rows.Scan(&byOpCode, &byTag, &byTZ, &opCode, &tag, &tz, &count); err != nil {
+			logger.Err(err).Msg("GetAudience")
+			return model.Audience{}, err
+		}
+
+		// grouping() is 0 for the columns the row is grouped by
+		switch {
+		case byOpCode == 0:
+			audience.ByOpCode[opCode] = count
+		case byTag == 0:
+			audience.ByTag[tag] = count
+		case byTZ == 0:
+			audience.ByTZ[tz] = count
+		default:
+			audience.Total = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Err(err).Msg("GetAudience")
+		return model.Audience{}, err
+	}
+
+	if audience.Total == 0 {
+		return audience, nil
+	}
+
+	b.args = append(b.args, limit, offset)
+	sampleRows, err := svc.pool.Query(ctx,
+		"select "+clientColumns+" from clients WHERE "+b.where()+
+			" ORDER BY phone LIMIT $"+strconv.Itoa(len(b.args)-1)+" OFFSET $"+strconv.Itoa(len(b.args)),
+		b.args...)
+	if err != nil {
+		logger.Err(err).Msg("GetAudience")
+		return model.Audience{}, err
+	}
+	defer sampleRows.Close()
+
+	for sampleRows.Next() {
+		client := model.Client{}
+		if err := scanClient(sampleRows, &client); err != nil {
+			logger.Err(err).Msg("GetAudience")
+			return model.Audience{}, err
+		}
+		audience.Sample = append(audience.Sample, client)
+	}
+	if err := sampleRows.Err(); err != nil {
+		logger.Err(err).Msg("GetAudience")
+		return model.Audience{}, err
+	}
+
+	return audience, nil
+}
+
+// CountAudience returns the number of clients matching the filter.
+func (svc *Storage) CountAudience(ctx context.Context, filter model.Filter) (int, error) {
+	logger := svc.Logger(ctx)
+
+	b := newWhereBuilder()
+	b.addFilter(filter)
+
+	var count int
+	if err := svc.pool.QueryRow(ctx,
+		"select count(*) from clients WHERE "+b.where(), b.args...).Scan(&count); err != nil {
+		logger.Err(err).Msg("CountAudience")
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// countAudiences returns the number of clients matching the filter of each sending,
+// counted by a single query.
+func (svc *Storage) countAudiences(ctx context.Context, sendings []*model.Sending) (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int, len(sendings))
+	if len(sendings) == 0 {
+		return counts, nil
+	}
+
+	var args []interface{}
+	selects := make([]string, 0, len(sendings))
+	for _, sending := range sendings {
+		b := newWhereBuilder(args...)
+		b.addFilter(sending.Filter)
+		args = append(b.args, sending.ID)
+		selects = append(selects, "select $"+strconv.Itoa(len(args))+"::uuid, count(*) from clients WHERE "+b.where())
+	}
+
+	rows, err := svc.pool.Query(ctx, strings.Join(selects, " union all "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,71 @@
+package psql
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"noty/model"
+	"noty/pkg"
+)
+
+// CreateMessageEvent stores a message status transition.
+func (svc *Storage) CreateMessageEvent(ctx context.Context, event model.MessageEvent) (model.MessageEvent, error) {
+	logger := svc.Logger(ctx)
+
+	err := svc.pool.QueryRow(ctx,
+		`insert into message_events(message_id, from_status, to_status, attempt, http_status, response, latency_ms, error)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id, created_at`,
+		event.MessageID, event.FromStatus.Int(), event.ToStatus.Int(), event.Attempt,
+		event.HTTPStatus, event.Response, event.LatencyMs, event.Error,
+	).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		logger.Err(err).Msg("creating message event")
+		return model.MessageEvent{}, err
+	}
+
+	return event, nil
+}
+
+// GetMessageEvents returns events of the sending message ordered by time.
+func (svc *Storage) GetMessageEvents(ctx context.Context, sendingID uuid.UUID, messageID int64) (model.MessageEvents, error) {
+	logger := svc.Logger(ctx)
+
+	rows, err := svc.pool.Query(ctx,
+		`select e.id, e.message_id, e.created_at, e.from_status, e.to_status, e.attempt,
+			e.http_status, e.response, e.latency_ms, e.error
+		from message_events e
+		join messages m on m.id = e.message_id
+		where m.sending_id = $1 and e.message_id = $2
+		order by e.created_at, e.id`,
+		sendingID, messageID)
+	if err != nil {
+		logger.Err(err).Msg("getting message events")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events model.MessageEvents
+	for rows.Next() {
+		var event model.MessageEvent
+		var from, to int
+		err := rows.Scan(&event.ID, &event.MessageID, &event.CreatedAt, &from, &to, &event.Attempt,
+			&event.HTTPStatus, &event.Response, &event.LatencyMs, &event.Error)
+		if err != nil {
+			logger.Err(err).Msg("getting message events")
+			return nil, err
+		}
+		event.FromStatus = model.NewMessageStatusFromInt(from)
+		event.ToStatus = model.NewMessageStatusFromInt(to)
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Err(err).Msg("getting message events")
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, pkg.ErrNoData
+	}
+
+	return events, nil
+}
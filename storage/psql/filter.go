@@ -0,0 +1,66 @@
+package psql
+
+import (
+	"encoding/json"
+	"noty/model"
+	"strconv"
+	"strings"
+)
+
+// whereBuilder builds a where clause from conditions with bound arguments.
+// Values never get into the SQL text, they are passed as query arguments.
+type whereBuilder struct {
+	conds []string
+	args  []interface{}
+}
+
+// newWhereBuilder creates a builder whose arguments follow args (e.g. $1 is taken).
+func newWhereBuilder(args ...interface{}) *whereBuilder {
+	return &whereBuilder{args: args}
+}
+
+// add adds the condition, "?" is replaced with the placeholder of arg.
+func (b *whereBuilder) add(cond string, arg interface{}) {
+	b.args = append(b.args, arg)
+	b.conds = append(b.conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(b.args))))
+}
+
+// where returns the where clause conditions, "true" if there are none.
+func (b *whereBuilder) where() string {
+	if len(b.conds) == 0 {
+		return "true"
+	}
+
+	return strings.Join(b.conds, " AND ")
+}
+
+// addFilter adds conditions matching clients of the filter, see model.Filter.
+func (b *whereBuilder) addFilter(filter model.Filter) {
+	if len(filter.Tags) > 0 {
+		b.add("clients.tag = ANY(?::text[])", filter.Tags)
+	}
+	if len(filter.Codes) > 0 {
+		b.add("clients.op_code = ANY(?::int[])", filter.Codes)
+	}
+	if len(filter.ExcludeTags) > 0 {
+		b.add("coalesce(clients.tag, '') <> ALL(?::text[])", filter.ExcludeTags)
+	}
+	if len(filter.ExcludeCodes) > 0 {
+		b.add("clients.op_code <> ALL(?::int[])", filter.ExcludeCodes)
+	}
+	if len(filter.PhonePrefixes) > 0 {
+		// prefixes are validated to be digits, so they contain no LIKE wildcards
+		patterns := make([]string, len(filter.PhonePrefixes))
+		for i, prefix := range filter.PhonePrefixes {
+			patterns[i] = prefix + "%"
+		}
+		b.add("clients.phone::text LIKE ANY(?::text[])", patterns)
+	}
+	if len(filter.TZs) > 0 {
+		b.add("clients.tz = ANY(?::text[])", filter.TZs)
+	}
+	if len(filter.Attrs) > 0 {
+		attrs, _ := json.Marshal(filter.Attrs)
+		b.add("clients.attrs @> ?::jsonb", string(attrs))
+	}
+}
@@ -0,0 +1,83 @@
+package psql
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"noty/model"
+	"time"
+)
+
+// enqueueSendingJob schedules processing of the sending at its start time.
+// An existing job of the sending is rescheduled and unlocked.
+func enqueueSendingJob(ctx context.Context, tx pgx.Tx, sending model.Sending) error {
+	_, err := tx.Exec(ctx,
+		`insert into sending_jobs(sending_id, run_at) values ($1, $2)
+		on conflict (sending_id) do update set run_at = excluded.run_at, locked_until = null, attempts = 0`,
+		sending.ID, sending.StartAt)
+
+	return err
+}
+
+// ClaimSendingJobs locks up to limit due sending jobs for the lease duration and
+// returns their sendings. Jobs locked by others are skipped, jobs with an expired
+// lock are claimed again.
+func (svc *Storage) ClaimSendingJobs(ctx context.Context, limit int, lease time.Duration) (model.Sendings, error) {
+	logger := svc.Logger(ctx)
+	var sendings model.Sendings
+
+	rows, err := svc.pool.Query(ctx,
+		`
+with claimed as (
+	select sending_id from sending_jobs
+	where run_at <= now() and (locked_until is null or locked_until < now())
+	order by run_at
+	limit $1
+	for update skip locked
+), locked as (
+	update sending_jobs set locked_until = now() + $2::bigint * interval '1 millisecond', attempts = attempts + 1
+	from claimed where sending_jobs.sending_id = claimed.sending_id
+	returning sending_jobs.sending_id, sending_jobs.run_at
+)
+select `+sendingColumns+` from sendings
+join locked on sendings.id = locked.sending_id
+order by locked.run_at`,
+		pgx.QueryResultFormats{pgx.BinaryFormatCode},
+		limit, lease.Milliseconds(),
+	)
+	if err != nil {
+		logger.Err(err).Msg("ClaimSendingJobs")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sending := model.Sending{}
+		if err := scanSending(rows, &sending); err != nil {
+			logger.Err(err).Msg("ClaimSendingJobs")
+			return nil, err
+		}
+		sendings = append(sendings, &sending)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Err(err).Msg("ClaimSendingJobs")
+		return nil, err
+	}
+
+	return sendings, nil
+}
+
+// CompleteSendingJob removes the processed sending job.
+// A job rescheduled meanwhile (unlocked by enqueueSendingJob) is kept.
+func (svc *Storage) CompleteSendingJob(ctx context.Context, sendingID uuid.UUID) error {
+	logger := svc.Logger(ctx)
+
+	if _, err := svc.pool.Exec(ctx,
+		`delete from sending_jobs where sending_id = $1 and locked_until is not null`, sendingID); err != nil {
+		logger.Err(err).Msg("CompleteSendingJob")
+		return err
+	}
+
+	return nil
+}
@@ -2,116 +2,381 @@ package psql
 
 import (
 	"context"
-	"errors"
 	"github.com/google/uuid"
-	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"noty/model"
 	"noty/pkg"
+	"strconv"
+	"time"
 )
 
-func (svc *Storage) CreateMessage(ctx context.Context, message model.Message) (model.Message, error) {
-	logger := svc.Logger(ctx)
-	logger.UpdateContext(message.GetLoggerContext)
-
-	Row := svc.pool.QueryRow(ctx,
-		`insert into messages(status, sending_id, client_id) values ($1, $2, $3) returning id, created_at`,
-		message.Status.Int(), message.SendingID, message.ClientID)
-
-	err := Row.Scan(&message.ID, &message.CreatedAt)
+// messageColumns lists messages columns in the order scanMessage expects them.
+const messageColumns = `messages.id, messages.created_at, messages.status, messages.sending_id, messages.client_id,
+	messages.attempts, messages.next_attempt_at, messages.last_error, messages.lease_owner, messages.outcome,
+	messages.sent_at`
 
+// scanMessage scans a row selected with messageColumns (plus extra destinations).
+func scanMessage(row pgx.Row, message *model.Message, dest ...interface{}) error {
+	var status, outcome int
+	var sentAt pgtype.Timestamptz
+	err := row.Scan(append([]interface{}{
+		&message.ID,
+		&message.CreatedAt,
+		&status,
+		&message.SendingID,
+		&message.ClientID,
+		&message.Attempts,
+		&message.NextAttemptAt,
+		&message.LastError,
+		&message.LeaseOwner,
+		&outcome,
+		&sentAt,
+	}, dest...)...)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			logger.Err(pgErr).Msg("creating message")
-			return model.Message{}, pgErr
-		}
-
-		logger.Err(err).Msg("creating message")
-		return model.Message{}, err
+		return err
+	}
+	message.Status = model.NewMessageStatusFromInt(status)
+	message.Outcome = model.NewDeliveryOutcomeFromInt(outcome)
+	if sentAt.Status == pgtype.Present {
+		message.SentAt = &sentAt.Time
 	}
 
-	//logger.Debug().Msgf("Message: %+v", message)
-
-	return message, nil
+	return nil
 }
 
-// UpdateMessage updates message.
+// UpdateMessage updates message and releases its lease.
+// A message leased by another owner or finished meanwhile (e.g. cancelled)
+// is not updated (pkg.ErrLeaseLost).
 func (svc *Storage) UpdateMessage(ctx context.Context, message model.Message) (model.Message, error) {
 	logger := svc.Logger(ctx)
 	logger.UpdateContext(message.GetLoggerContext)
 
 	res, err := svc.pool.Exec(ctx,
-		`update messages set status = $1, created_at = $2 where id = $3`,
-		message.Status.Int(), message.CreatedAt, message.ID)
+		`update messages set status = $1, sent_at = $2, attempts = $3, next_attempt_at = $4, last_error = $5,
+			outcome = $6, lease_owner = '', lease_expires_at = null
+		where id = $7 and lease_owner in ('', $8) and status = ANY($9::int[])`,
+		message.Status.Int(), message.SentAt, message.Attempts, message.NextAttemptAt, message.LastError,
+		message.Outcome.Int(), message.ID, message.LeaseOwner,
+		[]int{model.MessageStatusNew.Int(), model.MessageStatusQueued.Int(), model.MessageStatusSending.Int()})
 	if err != nil {
 		logger.Err(err).Msg("updating message")
 		return model.Message{}, err
 	}
 
 	if res.RowsAffected() == 0 {
-		logger.Err(pkg.ErrNotExists).Msg("updating message")
-		return model.Message{}, pkg.ErrNotExists
+		logger.Err(pkg.ErrLeaseLost).Msg("updating message")
+		return model.Message{}, pkg.ErrLeaseLost
 	}
+	message.LeaseOwner = ""
 
 	return message, nil
 }
 
-func (svc *Storage) GetMessageByClientAndSendingID(ctx context.Context, clientID uuid.UUID, sendingID uuid.UUID) (model.Message, error) {
+// CreateSendingMessages creates NEW messages for all clients matching the sending filter
+// which have none yet and records their creation, in one statement.
+func (svc *Storage) CreateSendingMessages(ctx context.Context, sending model.Sending) (int64, error) {
 	logger := svc.Logger(ctx)
+	logger.UpdateContext(sending.GetLoggerContext)
 
-	var message model.Message
-	var status int
-	err := svc.pool.QueryRow(ctx,
-		`select id, status, created_at from messages where client_id = $1 and sending_id = $2`,
-		clientID, sendingID).Scan(&message.ID, &status, &message.CreatedAt)
+	created, err := createSendingMessages(ctx, svc.pool, sending)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			logger.Err(err).Msg("getting message")
-		}
-		return model.Message{}, err
+		logger.Err(err).Msg("creating sending messages")
+		return 0, err
 	}
-	message.Status = model.NewMessageStatusFromInt(status)
 
-	return message, nil
+	if created > 0 {
+		logger.Info().Msgf("Created %v messages", created)
+	}
+
+	return created, nil
+}
+
+// createSendingMessages inserts messages of the sending in bulk, see CreateSendingMessages.
+func createSendingMessages(ctx context.Context, db execer, sending model.Sending) (int64, error) {
+	b := newWhereBuilder(model.MessageStatusNew.Int(), sending.ID)
+	b.addFilter(sending.Filter)
+	res, err := db.Exec(ctx,
+		`with created as (
+			insert into messages(status, sending_id, client_id)
+			select $1, $2, clients.id from clients where `+b.where()+`
+				and not exists (select 1 from messages
+					where messages.sending_id = $2 and messages.client_id = clients.id)
+			on conflict (sending_id, client_id) do nothing
+			returning id
+		)
+		insert into message_events(message_id, to_status)
+		select id, $1 from created`,
+		b.args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected(), nil
 }
 
-// GetMessagesBySendingID returns messages by sending ID.
-func (svc *Storage) GetMessagesBySendingID(ctx context.Context, sendingID uuid.UUID) (model.Messages, error) {
+// CancelUnmatchedMessages cancels NEW messages of the sending whose clients no longer
+// match its filter and records the transitions, so a dynamic audience can complete.
+// Clients matching the filter again later are not messaged.
+func (svc *Storage) CancelUnmatchedMessages(ctx context.Context, sending model.Sending) (int64, error) {
 	logger := svc.Logger(ctx)
+	logger.UpdateContext(sending.GetLoggerContext)
+
+	b := newWhereBuilder(model.MessageStatusCancelled.Int(), sending.ID, model.MessageStatusNew.Int())
+	b.addFilter(sending.Filter)
+	res, err := svc.pool.Exec(ctx,
+		`with unmatched as (
+			select messages.id from messages
+			join clients on clients.id = messages.client_id
+			where messages.sending_id = $2 and messages.status = $3
+				and not coalesce((`+b.where()+`), false)
+			for update of messages skip locked
+		), updated as (
+			update messages set status = $1
+			from unmatched where messages.id = unmatched.id
+			returning messages.id
+		)
+		insert into message_events(message_id, from_status, to_status)
+		select id, $3, $1 from updated`,
+		b.args...)
+	if err != nil {
+		logger.Err(err).Msg("cancelling unmatched messages")
+		return 0, err
+	}
+
+	if res.RowsAffected() > 0 {
+		logger.Info().Msgf("Cancelled %v messages of clients no longer matching", res.RowsAffected())
+	}
+
+	return res.RowsAffected(), nil
+}
+
+// ClaimMessages leases up to limit due messages (NEW or QUEUED with an expired lease)
+// of the sending to the owner, marks them QUEUED and returns them with their clients.
+// For a dynamic audience only messages of clients matching the filter are claimed.
+// Nothing is claimed if the stored sending is no longer active (e.g. paused meanwhile).
+func (svc *Storage) ClaimMessages(ctx context.Context, sending model.Sending, owner string, lease time.Duration, limit int) (model.SendableMessages, error) {
+	logger := svc.Logger(ctx)
+
+	b := newWhereBuilder(
+		sending.ID,
+		[]int{model.MessageStatusNew.Int(), model.MessageStatusQueued.Int()},
+		limit,
+		model.MessageStatusQueued.Int(), owner, lease.Milliseconds(),
+		[]int{model.SendingStateScheduled.Int(), model.SendingStateRunning.Int()},
+	)
+	if sending.AudienceMode == model.AudienceModeDynamic {
+		b.addFilter(sending.Filter)
+	}
 
 	rows, err := svc.pool.Query(ctx,
-		`select id, created_at, status, sending_id, client_id from messages where sending_id = $1`,
-		sendingID)
+		`
+with claimed as (
+	select messages.id, messages.status from messages
+	join clients on clients.id = messages.client_id
+	join sendings on sendings.id = messages.sending_id
+	where messages.sending_id = $1 and messages.status = ANY($2::int[]) and messages.next_attempt_at <= now()
+		and sendings.state = ANY($7::int[])
+		and (messages.lease_expires_at is null or messages.lease_expires_at < now())
+		and `+b.where()+`
+	order by messages.next_attempt_at, messages.id
+	limit $3
+	for update of messages skip locked
+), leased as (
+	update messages set status = $4, lease_owner = $5, lease_expires_at = now() + $6::bigint * interval '1 millisecond'
+	from claimed where messages.id = claimed.id
+	returning `+messageColumns+`, claimed.status as from_status
+), events as (
+	insert into message_events(message_id, from_status, to_status)
+	select id, from_status, $4 from leased where from_status <> $4
+)
+select leased.*, `+clientColumns+` from leased
+join clients on clients.id = leased.client_id
+order by leased.next_attempt_at, leased.id`,
+		b.args...,
+	)
 	if err != nil {
-		logger.Err(err).Msg("getting messages")
+		logger.Err(err).Msg("claiming messages")
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages model.Messages
+	var messages model.SendableMessages
 	for rows.Next() {
-		var message model.Message
-		var status int
-		err := rows.Scan(&message.ID, &message.CreatedAt, &status, &message.SendingID, &message.ClientID)
-		if err != nil {
-			logger.Err(err).Msg("getting messages")
+		var m model.SendableMessage
+		var fromStatus int
+		if err := scanMessage(rows, &m.Message, append([]interface{}{&fromStatus}, clientDest(&m.Client)...)...); err != nil {
+			logger.Err(err).Msg("claiming messages")
 			return nil, err
 		}
-		message.Status = model.NewMessageStatusFromInt(status)
-		messages = append(messages, &message)
-
+		messages = append(messages, &m)
 	}
 
 	if err = rows.Err(); err != nil {
-		logger.Err(err).Msg("getting messages")
+		logger.Err(err).Msg("claiming messages")
 		return nil, err
 	}
 
-	if len(messages) == 0 {
-		logger.Err(pkg.ErrNoData).Msg("getting messages")
-		return nil, pkg.ErrNoData
+	return messages, nil
+}
+
+// AcquireMessage moves a QUEUED message leased by message.LeaseOwner to SENDING.
+// Only the lease owner may send the message: if the message was taken over,
+// pkg.ErrLeaseLost is returned and the message must not be sent.
+// If the sending is no longer active (e.g. paused), pkg.ErrSendingInactive is returned.
+// The claim lease must not have expired (the message may be claimed by another replica then),
+// it is renewed for the provider call, so the message isn't taken for abandoned meanwhile.
+func (svc *Storage) AcquireMessage(ctx context.Context, message model.Message, lease time.Duration) error {
+	logger := svc.Logger(ctx)
+	logger.UpdateContext(message.GetLoggerContext)
+
+	activeStates := []int{model.SendingStateScheduled.Int(), model.SendingStateRunning.Int()}
+	res, err := svc.pool.Exec(ctx,
+		`update messages set status = $1, lease_expires_at = now() + $6::bigint * interval '1 millisecond'
+		where id = $2 and status = $3 and lease_owner = $4 and lease_expires_at > now()
+			and exists (select 1 from sendings where sendings.id = messages.sending_id and sendings.state = ANY($5::int[]))`,
+		model.MessageStatusSending.Int(), message.ID, model.MessageStatusQueued.Int(), message.LeaseOwner,
+		activeStates, lease.Milliseconds())
+	if err != nil {
+		logger.Err(err).Msg("acquiring message")
+		return err
 	}
 
-	return messages, nil
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+
+	var leased bool
+	err = svc.pool.QueryRow(ctx,
+		`select exists (select 1 from messages
+			where id = $1 and status = $2 and lease_owner = $3 and lease_expires_at > now())`,
+		message.ID, model.MessageStatusQueued.Int(), message.LeaseOwner).Scan(&leased)
+	if err != nil {
+		logger.Err(err).Msg("acquiring message")
+		return err
+	}
+	if leased {
+		return pkg.ErrSendingInactive
+	}
+
+	return pkg.ErrLeaseLost
+}
+
+// FailAbandonedMessages fails SENDING messages whose lease expired: the sender died
+// during the provider call, so the delivery is unknown and the message is not resent.
+func (svc *Storage) FailAbandonedMessages(ctx context.Context) (int64, error) {
+	logger := svc.Logger(ctx)
+
+	res, err := svc.pool.Exec(ctx,
+		`
+with abandoned as (
+	update messages set status = $1, last_error = $3, lease_owner = '', lease_expires_at = null
+	where status = $2 and lease_expires_at < now()
+	returning id
+)
+insert into message_events(message_id, from_status, to_status, error)
+select id, $2, $1, $3 from abandoned`,
+		model.MessageStatusFailed.Int(), model.MessageStatusSending.Int(),
+		"lease expired while sending, delivery unknown")
+	if err != nil {
+		logger.Err(err).Msg("failing abandoned messages")
+		return 0, err
+	}
+
+	if res.RowsAffected() > 0 {
+		logger.Warn().Msgf("Failed %v abandoned messages", res.RowsAffected())
+	}
+
+	return res.RowsAffected(), nil
+}
+
+// ExpireMessages marks unsent messages of sendings past their stop_at as expired.
+func (svc *Storage) ExpireMessages(ctx context.Context) (int64, error) {
+	logger := svc.Logger(ctx)
+
+	res, err := svc.pool.Exec(ctx,
+		`with expired as (
+			select messages.id, messages.status from messages
+			join sendings on messages.sending_id = sendings.id
+			where sendings.stop_at <= now() and messages.status = ANY($2::int[])
+			for update of messages
+		), updated as (
+			update messages set status = $1 from expired where messages.id = expired.id
+			returning messages.id, expired.status
+		)
+		insert into message_events(message_id, from_status, to_status)
+		select id, status, $1 from updated`,
+		model.MessageStatusExpired.Int(),
+		[]int{model.MessageStatusNew.Int(), model.MessageStatusQueued.Int()})
+	if err != nil {
+		logger.Err(err).Msg("expiring messages")
+		return 0, err
+	}
+
+	if res.RowsAffected() > 0 {
+		logger.Info().Msgf("Expired %v messages", res.RowsAffected())
+	}
+
+	return res.RowsAffected(), nil
+}
+
+// ListSendingMessages returns a page of messages of the sending matching the query.
+func (svc *Storage) ListSendingMessages(ctx context.Context, sendingID uuid.UUID, query model.MessageQuery) (model.MessagesPage, error) {
+	logger := svc.Logger(ctx)
+
+	key, ok := messageSortColumns[query.Sort]
+	if !ok {
+		return model.MessagesPage{}, pkg.ErrInvalidInput
+	}
+
+	b := newWhereBuilder()
+	b.add("messages.sending_id = ?", sendingID)
+	if len(query.Statuses) > 0 {
+		statuses := make([]int, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = status.Int()
+		}
+		b.add("messages.status = ANY(?::int[])", statuses)
+	}
+	if query.CreatedFrom != nil {
+		b.add("messages.created_at >= ?", *query.CreatedFrom)
+	}
+	if query.CreatedTo != nil {
+		b.add("messages.created_at < ?", *query.CreatedTo)
+	}
+	orderBy, limit := b.addPage(query.Page, key, messageIDColumn)
+
+	rows, err := svc.pool.Query(ctx,
+		`select `+messageColumns+`, `+key.sortKey()+` from messages where `+b.where()+orderBy+limit,
+		b.args...)
+	if err != nil {
+		logger.Err(err).Msg("listing messages")
+		return model.MessagesPage{}, pageError(err)
+	}
+	defer rows.Close()
+
+	page := model.MessagesPage{Items: model.Messages{}}
+	var keys []string
+	for rows.Next() {
+		var message model.Message
+		var sortKey string
+		if err := scanMessage(rows, &message, &sortKey); err != nil {
+			logger.Err(err).Msg("listing messages")
+			return model.MessagesPage{}, err
+		}
+		page.Items = append(page.Items, &message)
+		keys = append(keys, sortKey)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Err(err).Msg("listing messages")
+		return model.MessagesPage{}, pageError(err)
+	}
+
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		last := page.Items[query.Limit-1]
+		page.Next = model.Cursor{Key: keys[query.Limit-1], ID: strconv.FormatInt(last.ID, 10)}.String()
+	}
+
+	return page, nil
 }
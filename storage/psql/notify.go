@@ -0,0 +1,124 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"noty/pkg"
+	"time"
+)
+
+const (
+	// sendingsChannel is the NOTIFY channel of inserted/updated sendings.
+	sendingsChannel = "sendings"
+
+	// listenRetryDelay is the delay before re-establishing a broken LISTEN connection.
+	listenRetryDelay = time.Second
+)
+
+// ListenSendings subscribes to sendings inserts and updates (from any replica).
+// The channel receives the sending ID; uuid.Nil is sent after a reconnect as
+// notifications might have been missed. The channel is closed when ctx is done.
+func (svc *Storage) ListenSendings(ctx context.Context) (<-chan uuid.UUID, error) {
+	conn, err := svc.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan uuid.UUID, 1)
+	go func() {
+		defer close(ch)
+		logger := svc.Logger(ctx)
+
+		for {
+			if conn == nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(listenRetryDelay):
+				}
+
+				if conn, err = svc.listen(ctx); err != nil {
+					logger.Err(err).Msg("ListenSendings")
+					continue
+				}
+				select {
+				case ch <- uuid.Nil:
+				case <-ctx.Done():
+				}
+			}
+
+			n, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				svc.unlisten(conn)
+				conn = nil
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Err(err).Msg("ListenSendings: waiting for notification")
+				continue
+			}
+
+			id, err := uuid.Parse(n.Payload)
+			if err != nil {
+				logger.Err(err).Msgf("ListenSendings: unexpected payload %q", n.Payload)
+				continue
+			}
+
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// NextSendingJobAt returns the time the next sending job becomes due.
+// Returns pkg.ErrNoData if there are no jobs.
+func (svc *Storage) NextSendingJobAt(ctx context.Context) (time.Time, error) {
+	logger := svc.Logger(ctx)
+
+	var next *time.Time
+	err := svc.pool.QueryRow(ctx,
+		`select min(greatest(run_at, coalesce(locked_until, run_at))) from sending_jobs`,
+	).Scan(&next)
+	if err != nil {
+		logger.Err(err).Msg("NextSendingJobAt")
+		return time.Time{}, err
+	}
+
+	if next == nil {
+		return time.Time{}, pkg.ErrNoData
+	}
+
+	return *next, nil
+}
+
+// listen acquires a dedicated connection listening to the sendings channel.
+func (svc *Storage) listen(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := svc.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "listen "+sendingsChannel); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	return conn, nil
+}
+
+// unlisten returns the listening connection to the pool.
+func (svc *Storage) unlisten(conn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), svc.config.timeout)
+	defer cancel()
+
+	if !conn.Conn().IsClosed() {
+		conn.Exec(ctx, "unlisten *")
+	}
+	conn.Release()
+}
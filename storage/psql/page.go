@@ -0,0 +1,78 @@
+package psql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"noty/model"
+	"noty/pkg"
+	"strings"
+)
+
+// sortColumn is an expression a list is sorted by and the SQL type of its values.
+type sortColumn struct {
+	expr, typ string
+}
+
+var (
+	clientSortColumns = map[string]sortColumn{
+		"phone":   {"clients.phone", "bigint"},
+		"op_code": {"clients.op_code", "int"},
+		"tag":     {"coalesce(clients.tag, '')", "text"},
+		"tz":      {"coalesce(clients.tz, '')", "text"},
+	}
+	clientIDColumn = sortColumn{"clients.id", "uuid"}
+
+	sendingSortColumns = map[string]sortColumn{
+		"start_at": {"sendings.start_at", "timestamptz"},
+		"stop_at":  {"sendings.stop_at", "timestamptz"},
+		"state":    {"sendings.state", "int"},
+	}
+	sendingIDColumn = sortColumn{"sendings.id", "uuid"}
+
+	messageSortColumns = map[string]sortColumn{
+		"id":              {"messages.id", "bigint"},
+		"created_at":      {"messages.created_at", "timestamptz"},
+		"status":          {"messages.status", "int"},
+		"next_attempt_at": {"messages.next_attempt_at", "timestamptz"},
+	}
+	messageIDColumn = sortColumn{"messages.id", "bigint"}
+)
+
+// addPage adds the condition selecting rows after the page cursor. Returns the order by
+// clause and the limit clause, which selects one more row than the page to detect the next one.
+// Cursor keys are the sort expression values as text, they are cast back to the column type.
+func (b *whereBuilder) addPage(page model.Page, key, id sortColumn) (string, string) {
+	dir, cmp := "ASC", ">"
+	if page.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if page.After != nil {
+		b.args = append(b.args, page.After.Key, page.After.ID)
+		n := len(b.args)
+		b.conds = append(b.conds, fmt.Sprintf("(%s, %s) %s ($%d::text::%s, $%d::text::%s)",
+			key.expr, id.expr, cmp, n-1, key.typ, n, id.typ))
+	}
+
+	b.args = append(b.args, page.Limit+1)
+
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s", key.expr, dir, id.expr, dir),
+		fmt.Sprintf(" LIMIT $%d", len(b.args))
+}
+
+// sortKey returns the text of the sort expression to select along with the rows for cursors.
+func (c sortColumn) sortKey() string {
+	return "(" + c.expr + ")::text"
+}
+
+// pageError turns errors caused by a malformed cursor into pkg.ErrInvalidInput.
+func pageError(err error) error {
+	var pgErr *pgconn.PgError
+	// class 22 - data exception, e.g. invalid text representation
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "22") {
+		return fmt.Errorf("after: %w", pkg.ErrInvalidInput)
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+package psql
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+)
+
+// TakeRateTokens takes up to n tokens of the rate limit bucket shared by all replicas.
+// The bucket row is locked, so concurrent takes of replicas never exceed its tokens.
+func (svc *Storage) TakeRateTokens(ctx context.Context, key string, rate float64, burst, n int) (int, error) {
+	logger := svc.Logger(ctx)
+
+	var taken int
+	err := svc.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`insert into rate_buckets(key, tokens, updated_at) values ($1, $2, clock_timestamp())
+			on conflict (key) do nothing`,
+			key, float64(burst)); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx,
+			`
+with refilled as (
+	select key, least($3::float8,
+		tokens + greatest(0, extract(epoch from clock_timestamp() - updated_at)) * $2::float8) as tokens
+	from rate_buckets where key = $1
+	for update
+), taken as (
+	select key, tokens, least(floor(tokens), $4::int)::int as n from refilled
+)
+update rate_buckets set tokens = taken.tokens - taken.n, updated_at = clock_timestamp()
+from taken where rate_buckets.key = taken.key
+returning taken.n`,
+			key, rate, float64(burst), n).Scan(&taken)
+	})
+	if err != nil {
+		logger.Err(err).Msg("TakeRateTokens")
+		return 0, err
+	}
+
+	return taken, nil
+}
@@ -3,23 +3,83 @@ package psql
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"noty/model"
 	"noty/pkg"
+	"time"
 )
 
+// sendingColumns lists sendings columns in the order scanSending expects them.
+const sendingColumns = `sendings.id, sendings.start_at, sendings.text, sendings.filter, sendings.stop_at,
+	sendings.window_from, sendings.window_to, sendings.state,
+	sendings.recurrence_cron, sendings.recurrence_tz, sendings.recurrence_duration,
+	sendings.parent_id, sendings.next_run_at, sendings.audience_mode, sendings.snapshot_at`
+
+// scanSending scans a row selected with sendingColumns (plus extra destinations).
+// Rows must be queried in binary format to decode the filter.
+func scanSending(row pgx.Row, sending *model.Sending, dest ...interface{}) error {
+	var state int
+	var recurrence model.Recurrence
+	var parentID pgtype.UUID
+	var nextRunAt, snapshotAt pgtype.Timestamptz
+	var audienceMode int
+	err := row.Scan(append([]interface{}{
+		&sending.ID,
+		&sending.StartAt,
+		&sending.Text,
+		&sending.Filter,
+		&sending.StopAt,
+		&sending.Window.From,
+		&sending.Window.To,
+		&state,
+		&recurrence.Cron,
+		&recurrence.TZ,
+		&recurrence.Duration,
+		&parentID,
+		&nextRunAt,
+		&audienceMode,
+		&snapshotAt,
+	}, dest...)...)
+	if err != nil {
+		return err
+	}
+	sending.State = model.NewSendingStateFromInt(state)
+	if recurrence.Cron != "" {
+		sending.Recurrence = &recurrence
+	}
+	if parentID.Status == pgtype.Present {
+		id := uuid.UUID(parentID.Bytes)
+		sending.ParentID = &id
+	}
+	if nextRunAt.Status == pgtype.Present {
+		sending.NextRunAt = &nextRunAt.Time
+	}
+	sending.AudienceMode = model.NewAudienceModeFromInt(audienceMode)
+	if snapshotAt.Status == pgtype.Present {
+		sending.SnapshotAt = &snapshotAt.Time
+	}
+
+	return nil
+}
+
 // CreateSending creates a new model.Sending.
 func (svc *Storage) CreateSending(ctx context.Context, sending model.Sending) (model.Sending, error) {
 	logger := svc.Logger(ctx)
 	logger.UpdateContext(sending.GetLoggerContext)
 
-	// insert into sendings(text, filter) values ('hello world!', ('{"vip1","vip2"}','{911, 912, 913}'));
-	_, err := svc.pool.Exec(ctx,
-		`insert into sendings(id, start_at, text, filter, stop_at) values ($1, $2, $3, $4, $5)`,
-		sending.ID,
-		sending.StartAt, sending.Text, sending.Filter, sending.StopAt)
+	// The sending and its job are written in one transaction (outbox):
+	// a stored sending is always picked up by the sender.
+	err := svc.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := insertSending(ctx, tx, sending); err != nil {
+			return err
+		}
+
+		return enqueueSendingJob(ctx, tx, sending)
+	})
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -38,6 +98,74 @@ func (svc *Storage) CreateSending(ctx context.Context, sending model.Sending) (m
 	return sending, nil
 }
 
+// insertSending inserts the sending. A run already spawned by its template
+// for the same start is skipped: returns false then.
+func insertSending(ctx context.Context, tx pgx.Tx, sending model.Sending) (bool, error) {
+	var recurrence model.Recurrence
+	if sending.Recurrence != nil {
+		recurrence = *sending.Recurrence
+	}
+
+	// insert into sendings(text, filter) values ('hello world!', ('{"vip1","vip2"}','{911, 912, 913}'));
+	res, err := tx.Exec(ctx,
+		`insert into sendings(id, start_at, text, filter, stop_at, window_from, window_to, state,
+			recurrence_cron, recurrence_tz, recurrence_duration, parent_id, audience_mode)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		on conflict (parent_id, start_at) do nothing`,
+		sending.ID,
+		sending.StartAt, sending.Text, sending.Filter, sending.StopAt,
+		sending.Window.From, sending.Window.To, sending.State.Int(),
+		recurrence.Cron, recurrence.TZ, recurrence.Duration, sending.ParentID, sending.AudienceMode.Int())
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// SpawnSendingRun stores the run spawned by the template (if any) along with its job
+// and reschedules the template job at next. Zero next means no more runs.
+func (svc *Storage) SpawnSendingRun(ctx context.Context, template model.Sending, run *model.Sending, next time.Time) error {
+	logger := svc.Logger(ctx)
+	logger.UpdateContext(template.GetLoggerContext)
+
+	err := svc.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if run != nil {
+			inserted, err := insertSending(ctx, tx, *run)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				if err := enqueueSendingJob(ctx, tx, *run); err != nil {
+					return err
+				}
+				logger.Info().Msgf("Spawned sending run %s at %s", run.ID, run.StartAt)
+			}
+		}
+
+		var nextRunAt *time.Time
+		if !next.IsZero() {
+			nextRunAt = &next
+		}
+		if _, err := tx.Exec(ctx, `update sendings set next_run_at = $1 where id = $2`,
+			nextRunAt, template.ID); err != nil {
+			return err
+		}
+		if nextRunAt == nil {
+			return nil
+		}
+
+		template.StartAt = next
+		return enqueueSendingJob(ctx, tx, template)
+	})
+	if err != nil {
+		logger.Err(err).Msg("SpawnSendingRun")
+		return err
+	}
+
+	return nil
+}
+
 func (svc *Storage) DeleteSendingByID(ctx context.Context, id uuid.UUID) error {
 	logger := svc.Logger(ctx)
 
@@ -52,126 +180,354 @@ func (svc *Storage) DeleteSendingByID(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
+// UpdateSending updates the sending data, the state is kept.
+// The next run of a template is recomputed from the updated recurrence.
+// A frozen audience already selected is not affected by filter changes.
 func (svc *Storage) UpdateSending(ctx context.Context, sending model.Sending) (model.Sending, error) {
 	logger := svc.Logger(ctx)
 
-	res, err := svc.pool.Exec(ctx,
-		`UPDATE public.sendings SET start_at=$1, text=$2, filter=$3, stop_at=$4 WHERE id=$5;`,
-		sending.StartAt, sending.Text, sending.Filter, sending.StopAt, sending.ID)
+	err := svc.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var recurrence model.Recurrence
+		if sending.Recurrence != nil {
+			recurrence = *sending.Recurrence
+		}
+
+		var state, audienceMode int
+		// an omitted audience mode (0) keeps the stored one
+		err := tx.QueryRow(ctx,
+			`UPDATE public.sendings SET start_at=$1, text=$2, filter=$3, stop_at=$4, window_from=$5, window_to=$6,
+				recurrence_cron=$7, recurrence_tz=$8, recurrence_duration=$9, next_run_at=null,
+				audience_mode=coalesce(nullif($10::int, 0), audience_mode)
+			WHERE id=$11 RETURNING state, audience_mode;`,
+			sending.StartAt, sending.Text, sending.Filter, sending.StopAt,
+			sending.Window.From, sending.Window.To,
+			recurrence.Cron, recurrence.TZ, recurrence.Duration, sending.AudienceMode.Int(),
+			sending.ID).Scan(&state, &audienceMode)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pkg.ErrNotExists
+		}
+		if err != nil {
+			return err
+		}
+		sending.State = model.NewSendingStateFromInt(state)
+		sending.AudienceMode = model.NewAudienceModeFromInt(audienceMode)
+
+		return enqueueSendingJob(ctx, tx, sending)
+	})
 	if err != nil {
 		logger.Err(err).Msg("UpdateSending")
 		return model.Sending{}, err
 	}
 
-	if res.RowsAffected() == 0 {
-		logger.Err(pkg.ErrNotExists).Msg("UpdateSending")
-		return model.Sending{}, pkg.ErrNotExists
+	logger.Info().Msgf("Update sending %s", sending.ID)
+
+	return sending, nil
+}
+
+// UpdateSendingState changes the sending state validating the transition.
+// Starting a sending with a frozen audience creates messages for all matching
+// clients, cancelling a sending cancels its unsent messages. State changes the
+// sender must react to (pause, resume, cancel) reschedule the sending job.
+func (svc *Storage) UpdateSendingState(ctx context.Context, id uuid.UUID, to model.SendingState) (model.Sending, error) {
+	logger := svc.Logger(ctx)
+	var sending model.Sending
+
+	err := svc.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		err := scanSending(tx.QueryRow(ctx,
+			"select "+sendingColumns+" from sendings where id = $1 for update",
+			pgx.QueryResultFormats{pgx.BinaryFormatCode}, id), &sending)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pkg.ErrNotExists
+		}
+		if err != nil {
+			return err
+		}
+
+		if sending.State == to {
+			return nil
+		}
+		if !sending.State.CanTransitionTo(to) {
+			return fmt.Errorf("sending state transition %s -> %s: %w", sending.State, to, pkg.ErrInvalidTransition)
+		}
+
+		if _, err := tx.Exec(ctx, `update sendings set state = $1 where id = $2`, to.Int(), id); err != nil {
+			return err
+		}
+		sending.State = to
+
+		if to == model.SendingStateCancelled {
+			if err := cancelSendingMessages(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		if to == model.SendingStateRunning && sending.AudienceMode == model.AudienceModeFrozen &&
+			sending.SnapshotAt == nil && !sending.IsTemplate() {
+			snapshotAt, created, err := snapshotAudience(ctx, tx, sending)
+			if err != nil {
+				return err
+			}
+			sending.SnapshotAt = &snapshotAt
+			logger.Info().Msgf("Sending %s audience snapshot: %d messages", id, created)
+		}
+
+		switch to {
+		case model.SendingStateRunning, model.SendingStateCompleted:
+			// changed by the sender itself
+			return nil
+		}
+
+		return enqueueSendingJob(ctx, tx, sending)
+	})
+	if err != nil {
+		logger.Err(err).Msg("UpdateSendingState")
+		return model.Sending{}, err
+	}
+
+	logger.Info().Msgf("Sending %s state: %s", id, sending.State)
+
+	return sending, nil
+}
+
+// snapshotAudience creates NEW messages for all clients matching the sending filter
+// in bulk and records the snapshot time. Returns the snapshot time and the number of messages.
+func snapshotAudience(ctx context.Context, tx pgx.Tx, sending model.Sending) (time.Time, int64, error) {
+	created, err := createSendingMessages(ctx, tx, sending)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	var snapshotAt time.Time
+	if err := tx.QueryRow(ctx,
+		`update sendings set snapshot_at = now() where id = $1 returning snapshot_at`,
+		sending.ID).Scan(&snapshotAt); err != nil {
+		return time.Time{}, 0, err
+	}
+
+	return snapshotAt, created, nil
+}
+
+// cancelSendingMessages marks unsent (NEW and QUEUED) messages of the sending as cancelled
+// and records the transitions. Messages being sent are not affected.
+func cancelSendingMessages(ctx context.Context, tx pgx.Tx, sendingID uuid.UUID) error {
+	_, err := tx.Exec(ctx,
+		`with unsent as (
+			select id, status from messages
+			where sending_id = $2 and status = ANY($3::int[])
+			for update
+		), updated as (
+			update messages set status = $1, lease_owner = '', lease_expires_at = null
+			from unsent where messages.id = unsent.id
+			returning messages.id, unsent.status
+		)
+		insert into message_events(message_id, from_status, to_status)
+		select id, status, $1 from updated`,
+		model.MessageStatusCancelled.Int(), sendingID,
+		[]int{model.MessageStatusNew.Int(), model.MessageStatusQueued.Int()})
+
+	return err
+}
+
+// CompleteSendings marks sendings as completed: active or paused ones past their stop_at
+// and running ones with a frozen audience without unsent messages left. A dynamic audience
+// may grow until stop_at, so such sendings complete at stop_at only.
+func (svc *Storage) CompleteSendings(ctx context.Context) (int64, error) {
+	logger := svc.Logger(ctx)
+
+	res, err := svc.pool.Exec(ctx,
+		`update sendings set state = $1
+		where (state = ANY($2::int[]) and stop_at <= now())
+			or (state = $3 and audience_mode = $5 and snapshot_at is not null
+				and not exists (select 1 from messages
+					where messages.sending_id = sendings.id and messages.status = ANY($4::int[])))`,
+		model.SendingStateCompleted.Int(),
+		[]int{model.SendingStateScheduled.Int(), model.SendingStateRunning.Int(), model.SendingStatePaused.Int()},
+		model.SendingStateRunning.Int(),
+		[]int{model.MessageStatusNew.Int(), model.MessageStatusQueued.Int(), model.MessageStatusSending.Int()},
+		model.AudienceModeFrozen.Int())
+	if err != nil {
+		logger.Err(err).Msg("CompleteSendings")
+		return 0, err
+	}
+
+	if res.RowsAffected() > 0 {
+		logger.Info().Msgf("Completed %v sendings", res.RowsAffected())
 	}
 
-	logger.Info().Msgf("Update sending %s, %v rows affected", sending.ID, res.RowsAffected())
+	return res.RowsAffected(), nil
+}
+
+// GetSendingByID returns the sending. Returns pkg.ErrNotExists if there is no sending.
+func (svc *Storage) GetSendingByID(ctx context.Context, id uuid.UUID) (model.Sending, error) {
+	logger := svc.Logger(ctx)
+
+	var sending model.Sending
+	err := scanSending(svc.pool.QueryRow(ctx,
+		"select "+sendingColumns+" from sendings where id = $1",
+		pgx.QueryResultFormats{pgx.BinaryFormatCode}, id), &sending)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Sending{}, pkg.ErrNotExists
+		}
+		logger.Err(err).Msg("GetSendingByID")
+		return model.Sending{}, err
+	}
 
 	return sending, nil
 }
 
-func (svc *Storage) GetSendings(ctx context.Context) (model.Sendings, error) {
+// GetSendingStatus returns the sending with its message counts by status.
+// Returns pkg.ErrNotExists if there is no sending.
+func (svc *Storage) GetSendingStatus(ctx context.Context, id uuid.UUID) (model.SendingStatus, error) {
 	logger := svc.Logger(ctx)
-	var sendings model.Sendings
 
-	sendingsRows, err := svc.pool.Query(
-		ctx,
-		"select * from sendings ORDER BY start_at ASC",
-		pgx.QueryResultFormats{pgx.BinaryFormatCode},
-	)
+	sending, err := svc.GetSendingByID(ctx, id)
 	if err != nil {
-		logger.Err(err).Msg("GetSendings")
-		return nil, err //pgx.ErrNoRows
+		return model.SendingStatus{}, err
 	}
-	defer sendingsRows.Close()
+	status := model.NewSendingStatus(&sending)
 
-	for sendingsRows.Next() {
-		sending := model.Sending{}
-		err := sendingsRows.Scan(
-			&sending.ID,
-			&sending.StartAt,
-			&sending.Text,
-			&sending.Filter,
-			&sending.StopAt,
-		)
-		if err != nil {
-			logger.Err(err).Msg("GetSendings")
-			continue
+	rows, err := svc.pool.Query(ctx,
+		`select status, count(*) from messages where sending_id = $1 group by status`, id)
+	if err != nil {
+		logger.Err(err).Msg("GetSendingStatus")
+		return model.SendingStatus{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msgStatus, count int
+		if err := rows.Scan(&msgStatus, &count); err != nil {
+			logger.Err(err).Msg("GetSendingStatus")
+			return model.SendingStatus{}, err
 		}
-		sendings = append(sendings, &sending)
+		status.SetCount(model.NewMessageStatusFromInt(msgStatus), count)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Err(err).Msg("GetSendingStatus")
+		return model.SendingStatus{}, err
 	}
 
-	if len(sendings) == 0 {
-		return nil, pkg.ErrNoData
+	if !status.SetSnapshotAudience() {
+		audience, err := svc.CountAudience(ctx, sending.Filter)
+		if err != nil {
+			return model.SendingStatus{}, err
+		}
+		status.Audience = &audience
 	}
 
-	return sendings, nil
+	return *status, nil
 }
 
-// GetSendingsStatus returns the status of all sendings.
-func (svc *Storage) GetSendingsStatus(ctx context.Context) (model.SendingsStatus, error) {
+// ListSendingsStatus returns the status of a page of sendings matching the query.
+func (svc *Storage) ListSendingsStatus(ctx context.Context, query model.SendingQuery) (model.SendingsStatusPage, error) {
 	logger := svc.Logger(ctx)
-	var sendingsStatus model.SendingsStatus
 
-	sendingsRows, err := svc.pool.Query(
+	key, ok := sendingSortColumns[query.Sort]
+	if !ok {
+		return model.SendingsStatusPage{}, pkg.ErrInvalidInput
+	}
+
+	b := newWhereBuilder()
+	if len(query.States) > 0 {
+		states := make([]int, len(query.States))
+		for i, state := range query.States {
+			states[i] = state.Int()
+		}
+		b.add("sendings.state = ANY(?::int[])", states)
+	}
+	if query.StartFrom != nil {
+		b.add("sendings.start_at >= ?", *query.StartFrom)
+	}
+	if query.StartTo != nil {
+		b.add("sendings.start_at < ?", *query.StartTo)
+	}
+	orderBy, limit := b.addPage(query.Page, key, sendingIDColumn)
+
+	rows, err := svc.pool.Query(
 		ctx,
 		`
-WITH stnew AS
-(select  sending_id, count(status) as new from messages where status=1 group by sending_id),
-stsent AS
-(select  sending_id, count(status) as sent from messages where status=2 group by sending_id)
-
-select sendings.*, COALESCE(stnew.new,0),  COALESCE(stsent.sent,0) from sendings
-left join stnew on sendings.id=stnew.sending_id
-left join stsent on sendings.id=stsent.sending_id; `,
-		pgx.QueryResultFormats{pgx.BinaryFormatCode},
+select `+sendingColumns+`, `+key.sortKey()+`,
+	messages.status, count(messages.id)
+from sendings
+left join messages on sendings.id = messages.sending_id
+where sendings.id in (select sendings.id from sendings where `+b.where()+orderBy+limit+`)
+group by sendings.id, messages.status`+orderBy,
+		append([]interface{}{pgx.QueryResultFormats{pgx.BinaryFormatCode}}, b.args...)...,
 	)
 	if err != nil {
-		logger.Err(err).Msg("GetSendingsStatus")
-		return nil, err //pgx.ErrNoRows
+		logger.Err(err).Msg("ListSendingsStatus")
+		return model.SendingsStatusPage{}, pageError(err)
 	}
-	defer sendingsRows.Close()
+	defer rows.Close()
 
-	for sendingsRows.Next() {
+	page := model.SendingsStatusPage{Items: model.SendingsStatus{}}
+	var keys []string
+	var status *model.SendingStatus
+	for rows.Next() {
 		sending := model.Sending{}
-		status := model.SendingStatus{}
-		err := sendingsRows.Scan(
-			&sending.ID,
-			&sending.StartAt,
-			&sending.Text,
-			&sending.Filter,
-			&sending.StopAt,
-			&status.New,
-			&status.Sent,
-		)
-		if err != nil {
-			logger.Err(err).Msg("GetSendingsStatus")
+		var sortKey string
+		var msgStatus *int32
+		var count int
+		if err := scanSending(rows, &sending, &sortKey, &msgStatus, &count); err != nil {
+			logger.Err(err).Msg("ListSendingsStatus")
+			return model.SendingsStatusPage{}, err
+		}
+
+		if status == nil || status.Sending.ID != sending.ID {
+			status = model.NewSendingStatus(&sending)
+			page.Items = append(page.Items, status)
+			keys = append(keys, sortKey)
+		}
+
+		// sending without messages
+		if msgStatus == nil {
 			continue
 		}
-		status.Sending = &sending
-		sendingsStatus = append(sendingsStatus, &status)
+		status.SetCount(model.NewMessageStatusFromInt(int(*msgStatus)), count)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Err(err).Msg("ListSendingsStatus")
+		return model.SendingsStatusPage{}, pageError(err)
+	}
 
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		last := page.Items[query.Limit-1]
+		page.Next = model.Cursor{Key: keys[query.Limit-1], ID: last.Sending.ID.String()}.String()
 	}
-	if len(sendingsStatus) == 0 {
-		return nil, pkg.ErrNoData
 
+	// Audiences not frozen yet are the clients currently matching the filter.
+	var unfrozen []*model.Sending
+	for _, status := range page.Items {
+		if !status.SetSnapshotAudience() {
+			unfrozen = append(unfrozen, status.Sending)
+		}
+	}
+	counts, err := svc.countAudiences(ctx, unfrozen)
+	if err != nil {
+		logger.Err(err).Msg("ListSendingsStatus")
+		return model.SendingsStatusPage{}, err
+	}
+	for _, status := range page.Items {
+		if count, ok := counts[status.Sending.ID]; ok {
+			status.Audience = &count
+		}
 	}
 
-	return sendingsStatus, nil
+	return page, nil
 }
 
-// FilterCurrentSendings returns sendings for current time.
+// FilterCurrentSendings returns active sendings for current time.
 func (svc *Storage) FilterCurrentSendings(ctx context.Context) (model.Sendings, error) {
 	logger := svc.Logger(ctx)
 	var sendings model.Sendings
 
 	sendingsRows, err := svc.pool.Query(
 		ctx,
-		"select * from sendings WHERE start_at <= now() AND stop_at >= now() ORDER BY stop_at ASC",
+		"select "+sendingColumns+" from sendings WHERE start_at <= now() AND stop_at >= now() AND state = ANY($1::int[]) ORDER BY stop_at ASC",
 		pgx.QueryResultFormats{pgx.BinaryFormatCode},
+		[]int{model.SendingStateScheduled.Int(), model.SendingStateRunning.Int()},
 	)
 
 	if err != nil {
@@ -183,13 +539,7 @@ func (svc *Storage) FilterCurrentSendings(ctx context.Context) (model.Sendings,
 
 	for sendingsRows.Next() {
 		sending := model.Sending{}
-		err := sendingsRows.Scan(
-			&sending.ID,
-			&sending.StartAt,
-			&sending.Text,
-			&sending.Filter,
-			&sending.StopAt,
-		)
+		err := scanSending(sendingsRows, &sending)
 		if err != nil {
 			logger.Err(err).Msg("FilterCurrentSendings")
 			continue
@@ -3,6 +3,7 @@ package psql
 import (
 	"context"
 	"fmt"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
 	"noty/pkg/logging"
@@ -25,6 +26,11 @@ type (
 	}
 
 	option func(svc *Storage) error
+
+	// execer is implemented by both the pool and a transaction.
+	execer interface {
+		Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	}
 )
 
 // WithConfig sets Config.
@@ -112,6 +118,19 @@ func (svc *Storage) Migrate(ctx context.Context) error {
 		codes     bigint[]
 		);
 		END IF;
+
+		IF NOT EXISTS (SELECT * FROM pg_attribute att
+		INNER JOIN pg_type typ ON typ.typrelid = att.attrelid
+		INNER JOIN pg_namespace nsp ON nsp.oid = typ.typnamespace
+		WHERE nsp.nspname = current_schema()
+		AND typ.typname = 'filter' AND att.attname = 'exclude_tags') THEN
+		ALTER TYPE filter
+			ADD ATTRIBUTE exclude_tags text[],
+			ADD ATTRIBUTE exclude_codes bigint[],
+			ADD ATTRIBUTE phone_prefixes text[],
+			ADD ATTRIBUTE tzs text[],
+			ADD ATTRIBUTE attrs jsonb;
+		END IF;
 		
 		CREATE TABLE IF NOT EXISTS sendings
 		(
@@ -149,6 +168,84 @@ func (svc *Storage) Migrate(ctx context.Context) error {
 			foreign key (client_id) references clients (id) ON DELETE CASCADE,
 			unique (sending_id, client_id)
 		);
+
+		ALTER TABLE clients ADD COLUMN IF NOT EXISTS attrs jsonb not null default '{}';
+
+		-- concatenated SMS, the length is validated by the service
+		IF EXISTS (SELECT * FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'sendings'
+		AND column_name = 'text' AND data_type <> 'text') THEN
+		ALTER TABLE sendings ALTER COLUMN text TYPE text;
+		END IF;
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS window_from varchar(5) not null default '';
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS window_to varchar(5) not null default '';
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS state int not null default 1;
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS recurrence_cron varchar(128) not null default '';
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS recurrence_tz varchar(64) not null default '';
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS recurrence_duration int not null default 0;
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS next_run_at timestamp with time zone;
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS parent_id uuid references sendings (id) ON DELETE SET NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS sendings_parent_id_start_at_idx ON sendings (parent_id, start_at);
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS audience_mode int not null default 2;
+		ALTER TABLE sendings ADD COLUMN IF NOT EXISTS snapshot_at timestamp with time zone;
+
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS attempts int not null default 0;
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS next_attempt_at timestamp with time zone not null default now();
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_error text not null default '';
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS lease_owner text not null default '';
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS lease_expires_at timestamp with time zone;
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS outcome int not null default 0;
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS sent_at timestamp with time zone;
+		CREATE INDEX IF NOT EXISTS messages_sending_id_status_idx ON messages (sending_id, status);
+
+		CREATE TABLE IF NOT EXISTS sending_jobs
+		(
+			sending_id uuid not null,
+			run_at timestamp with time zone not null,
+			locked_until timestamp with time zone,
+			attempts int not null default 0,
+			created_at timestamp with time zone not null default now(),
+			primary key (sending_id),
+			foreign key (sending_id) references sendings (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS sending_jobs_run_at_idx ON sending_jobs (run_at);
+
+		CREATE OR REPLACE FUNCTION notify_sending_changed() RETURNS trigger AS $fn$
+		BEGIN
+			PERFORM pg_notify('sendings', NEW.id::text);
+			RETURN NEW;
+		END;
+		$fn$ LANGUAGE plpgsql;
+
+		IF NOT EXISTS (SELECT * FROM pg_trigger WHERE tgname = 'sendings_notify') THEN
+		CREATE TRIGGER sendings_notify AFTER INSERT OR UPDATE ON sendings
+			FOR EACH ROW EXECUTE PROCEDURE notify_sending_changed();
+		END IF;
+
+		CREATE TABLE IF NOT EXISTS message_events
+		(
+			id bigserial not null,
+			message_id bigint not null,
+			created_at timestamp with time zone not null default now(),
+			from_status int not null default 0,
+			to_status int not null,
+			attempt int not null default 0,
+			http_status int not null default 0,
+			response text not null default '',
+			latency_ms bigint not null default 0,
+			error text not null default '',
+			primary key (id),
+			foreign key (message_id) references messages (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS message_events_message_id_idx ON message_events (message_id);
+
+		CREATE TABLE IF NOT EXISTS rate_buckets
+		(
+			key varchar(64) not null,
+			tokens double precision not null,
+			updated_at timestamp with time zone not null default now(),
+			primary key (key)
+		);
 	END;
 	$$
 	`)
@@ -160,7 +257,7 @@ func (svc *Storage) Destroy(ctx context.Context) error {
 	logger := svc.Logger(ctx)
 	logger.Info().Msg("Drop Tables")
 
-	_, err := svc.pool.Exec(ctx, `drop table clients,sendings,messages;`)
+	_, err := svc.pool.Exec(ctx, `drop table clients,sendings,messages,message_events,sending_jobs,rate_buckets;`)
 
 	return err
 }